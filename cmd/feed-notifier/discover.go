@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/jamielinux/feed-notifier/internal/config"
+	"github.com/jamielinux/feed-notifier/internal/discover"
+	"github.com/mmcdole/gofeed"
+)
+
+// runDiscover prints the feeds linked from a web page, and returns the exit
+// status.
+func runDiscover(pageURL string) int {
+	client := &http.Client{Timeout: 30 * time.Second}
+
+	req, err := http.NewRequest("GET", pageURL, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid URL: %v\n", err)
+		return 1
+	}
+	req.Header.Set("User-Agent", config.DefaultUserAgent)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "HTTP request failed: %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "HTTP request failed: %s\n", resp.Status)
+		return 1
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 10*1024*1024))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read response: %v\n", err)
+		return 1
+	}
+
+	if parsedFeed, err := gofeed.NewParser().Parse(bytes.NewReader(body)); err == nil {
+		fmt.Printf("%s is already a feed: %s\n", pageURL, parsedFeed.Title)
+		return 0
+	}
+
+	candidates, err := discover.Find(body, resp.Request.URL.String())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to parse page: %v\n", err)
+		return 1
+	}
+	if len(candidates) == 0 {
+		fmt.Fprintf(os.Stderr, "No feeds found at %s\n", pageURL)
+		return 1
+	}
+
+	for _, candidate := range candidates {
+		fmt.Printf("%s\t%s\t%s\n", candidate.URL, candidate.Type, candidate.Title)
+	}
+	return 0
+}
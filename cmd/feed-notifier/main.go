@@ -16,6 +16,7 @@ import (
 func main() {
 	printUsage := func() {
 		fmt.Fprintln(os.Stderr, "Usage: feed-notifier CONFIG_FILE")
+		fmt.Fprintln(os.Stderr, "       feed-notifier discover URL")
 		os.Exit(1)
 	}
 
@@ -23,6 +24,13 @@ func main() {
 		printUsage()
 	}
 
+	if os.Args[1] == "discover" {
+		if len(os.Args) != 3 {
+			printUsage()
+		}
+		os.Exit(runDiscover(os.Args[2]))
+	}
+
 	configPath := os.Args[1]
 	if _, err := os.Stat(configPath); err != nil {
 		printUsage()
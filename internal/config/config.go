@@ -3,7 +3,11 @@ package config
 import (
 	"fmt"
 	"os"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/go-viper/mapstructure/v2"
 	"github.com/knadh/koanf/parsers/yaml"
 	"github.com/knadh/koanf/providers/file"
 	"github.com/knadh/koanf/v2"
@@ -11,13 +15,277 @@ import (
 
 // Feed represents an RSS/Atom feed to be monitored.
 type Feed struct {
-	ID          string `koanf:"id"`
-	URL         string `koanf:"url"`
-	DisplayName string `koanf:"display_name"`
-	Interval    int    `koanf:"interval"`
-	Notifier    string `koanf:"notifier"`
+	ID          string   `koanf:"id"`
+	URL         string   `koanf:"url"`
+	DisplayName string   `koanf:"display_name"`
+	Group       string   `koanf:"group"`
+	Tags        []string `koanf:"tags"`
+
+	// Command is a program and its arguments, which is run instead of
+	// fetching a URL. Its output is parsed as the feed.
+	Command []string `koanf:"command"`
+
+	// Type is how the response is parsed into articles.
+	Type   string         `koanf:"type"`
+	Scrape ScrapeSettings `koanf:"scrape"`
+	JSON   JSONSettings   `koanf:"json"`
+
+	FeedSettings `koanf:",squash"`
+}
+
+// IsLocal returns true if a feed is read from a file or a command rather than
+// fetched over HTTP.
+func (f *Feed) IsLocal() bool {
+	return len(f.Command) > 0 || strings.HasPrefix(strings.ToLower(f.URL), "file:")
+}
+
+// CanUseWebSub returns true if a feed can be subscribed to with WebSub, which
+// is only for RSS and Atom feeds fetched over HTTP.
+func (f *Feed) CanUseWebSub() bool {
+	return (f.Type == "" || f.Type == TypeFeed) && !f.IsLocal()
+}
+
+// Source returns the URL of a feed, or the command line for feeds that run a
+// command, for use in logs and alerts.
+func (f *Feed) Source() string {
+	if len(f.Command) > 0 {
+		return strings.Join(f.Command, " ")
+	}
+	return f.URL
+}
+
+// ScrapeSettings contains the CSS selectors used to extract articles from an
+// HTML page, for feeds of type scrape. Each element matching Items is an
+// article, and the other selectors are relative to it.
+type ScrapeSettings struct {
+	Items      string `koanf:"items"`
+	Title      string `koanf:"title"`
+	Link       string `koanf:"link"`
+	Date       string `koanf:"date"`
+	DateFormat string `koanf:"date_format"`
+	Content    string `koanf:"content"`
+}
+
+// JSONSettings contains the paths used to extract articles from a JSON
+// response, for feeds of type json. Items is the path of an array of objects,
+// each of which is an article, and the other paths are relative to each
+// object.
+type JSONSettings struct {
+	Items      string `koanf:"items"`
+	ID         string `koanf:"id"`
+	Title      string `koanf:"title"`
+	Link       string `koanf:"link"`
+	Date       string `koanf:"date"`
+	DateFormat string `koanf:"date_format"`
+	Content    string `koanf:"content"`
+}
+
+// Group is a named set of defaults that member feeds inherit.
+type Group struct {
+	ID           string   `koanf:"id"`
+	Tags         []string `koanf:"tags"`
+	FeedSettings `koanf:",squash"`
+}
+
+// FeedSettings contains the optional settings of a feed. These can also be
+// defined in a group, in which case member feeds inherit any settings that
+// they don't override. Settings where zero or false is meaningful are
+// pointers, so that a feed can override its group with zero or false.
+type FeedSettings struct {
+	Interval   Interval `koanf:"interval"`
+	Notifier   string   `koanf:"notifier"`
+	MaxAge     *int     `koanf:"max_age"`
+	Order      string   `koanf:"order"`
+	DedupScope string   `koanf:"dedup_scope"`
+
+	// The bounds of the interval when it's IntervalAuto.
+	MinInterval Interval `koanf:"min_interval"`
+	MaxInterval Interval `koanf:"max_interval"`
+
+	// WebSub subscribes to the feed's hub, if it has one.
+	WebSub *bool `koanf:"websub"`
+
+	// MaxPages is how many older pages of a paged or archived feed can be
+	// fetched to catch up on articles that were missed.
+	MaxPages *int `koanf:"max_pages"`
+
+	Schedule    []string `koanf:"schedule"`
+	ActiveHours string   `koanf:"active_hours"`
+	ActiveDays  []string `koanf:"active_days"`
+	Timezone    string   `koanf:"timezone"`
+
+	ArticleID            string   `koanf:"article_id"`
+	ArticleIDFields      []string `koanf:"article_id_fields"`
+	ArticleIDStripParams []string `koanf:"article_id_strip_params"`
+
+	HTTPSettings `koanf:",squash"`
+}
+
+// inherit sets any unspecified settings to the values in defaults.
+func (s *FeedSettings) inherit(defaults *FeedSettings) {
+	// A schedule overrides an interval, so a feed with its own interval
+	// doesn't inherit a schedule.
+	if len(s.Schedule) == 0 && s.Interval == 0 {
+		s.Schedule = defaults.Schedule
+	}
+	if s.Interval == 0 {
+		s.Interval = defaults.Interval
+	}
+	if s.Notifier == "" {
+		s.Notifier = defaults.Notifier
+	}
+	if s.MaxAge == nil {
+		s.MaxAge = defaults.MaxAge
+	}
+	if s.Order == "" {
+		s.Order = defaults.Order
+	}
+	if s.DedupScope == "" {
+		s.DedupScope = defaults.DedupScope
+	}
+	if s.MinInterval == 0 {
+		s.MinInterval = defaults.MinInterval
+	}
+	if s.MaxInterval == 0 {
+		s.MaxInterval = defaults.MaxInterval
+	}
+	if s.WebSub == nil {
+		s.WebSub = defaults.WebSub
+	}
+	if s.MaxPages == nil {
+		s.MaxPages = defaults.MaxPages
+	}
+	if s.ActiveHours == "" {
+		s.ActiveHours = defaults.ActiveHours
+	}
+	if len(s.ActiveDays) == 0 {
+		s.ActiveDays = defaults.ActiveDays
+	}
+	if s.Timezone == "" {
+		s.Timezone = defaults.Timezone
+	}
+	if s.ArticleID == "" {
+		s.ArticleID = defaults.ArticleID
+		if len(s.ArticleIDFields) == 0 {
+			s.ArticleIDFields = defaults.ArticleIDFields
+		}
+		if len(s.ArticleIDStripParams) == 0 {
+			s.ArticleIDStripParams = defaults.ArticleIDStripParams
+		}
+	}
+	s.HTTPSettings.inherit(&defaults.HTTPSettings)
+}
+
+// HTTPSettings contains options for the HTTP requests made when fetching
+// feeds. These can be defined globally in `fetch`, in a group or in a feed.
+type HTTPSettings struct {
+	UserAgent   string            `koanf:"user_agent"`
+	Headers     map[string]string `koanf:"headers"`
+	Cookies     map[string]string `koanf:"cookies"`
+	BasicAuth   BasicAuth         `koanf:"basic_auth"`
+	BearerToken string            `koanf:"bearer_token"`
+
+	Proxy              string   `koanf:"proxy"`
+	CAFiles            []string `koanf:"ca_files"`
+	ClientCert         string   `koanf:"client_cert"`
+	ClientKey          string   `koanf:"client_key"`
+	InsecureSkipVerify *bool    `koanf:"insecure_skip_verify"`
+
+	Timeout      int   `koanf:"timeout"`
+	MaxBodyBytes int64 `koanf:"max_body_bytes"`
+}
+
+// SkipVerify returns true if TLS certificates shouldn't be verified.
+func (h *HTTPSettings) SkipVerify() bool {
+	return h.InsecureSkipVerify != nil && *h.InsecureSkipVerify
+}
+
+// ProxyDirect is a `proxy` value that disables the use of a proxy, including
+// any proxy defined in the environment.
+const ProxyDirect = "direct"
+
+// BasicAuth contains credentials for HTTP basic authentication.
+type BasicAuth struct {
+	Username string `koanf:"username"`
+	Password string `koanf:"password"`
+}
+
+// inherit sets any unspecified settings to the values in defaults. Headers
+// and cookies are merged, with existing values taking precedence, and CA files
+// are combined.
+func (h *HTTPSettings) inherit(defaults *HTTPSettings) {
+	if h.UserAgent == "" {
+		h.UserAgent = defaults.UserAgent
+	}
+	h.Headers = mergeMaps(h.Headers, defaults.Headers)
+	h.Cookies = mergeMaps(h.Cookies, defaults.Cookies)
+	if h.BasicAuth.Username == "" && h.BearerToken == "" {
+		h.BasicAuth = defaults.BasicAuth
+		h.BearerToken = defaults.BearerToken
+	}
+	if h.Proxy == "" {
+		h.Proxy = defaults.Proxy
+	}
+	for _, caFile := range defaults.CAFiles {
+		if !slices.Contains(h.CAFiles, caFile) {
+			h.CAFiles = append(h.CAFiles, caFile)
+		}
+	}
+	if h.ClientCert == "" {
+		h.ClientCert = defaults.ClientCert
+		h.ClientKey = defaults.ClientKey
+	}
+	if h.InsecureSkipVerify == nil {
+		h.InsecureSkipVerify = defaults.InsecureSkipVerify
+	}
+	if h.Timeout == 0 {
+		h.Timeout = defaults.Timeout
+	}
+	if h.MaxBodyBytes == 0 {
+		h.MaxBodyBytes = defaults.MaxBodyBytes
+	}
+}
+
+// mergeMaps returns a new map containing the entries of m and any entries of
+// defaults whose keys aren't in m.
+func mergeMaps(m map[string]string, defaults map[string]string) map[string]string {
+	if len(defaults) == 0 {
+		return m
+	}
+	merged := make(map[string]string, len(m)+len(defaults))
+	for k, v := range defaults {
+		merged[k] = v
+	}
+	for k, v := range m {
+		merged[k] = v
+	}
+	return merged
 }
 
+const (
+	TypeFeed   = "feed"
+	TypeScrape = "scrape"
+	TypeJSON   = "json"
+)
+
+const (
+	OrderFeed          = "feed"
+	OrderChronological = "chronological"
+)
+
+const (
+	ArticleIDDefault        = "default"
+	ArticleIDGUID           = "guid"
+	ArticleIDLink           = "link"
+	ArticleIDTitle          = "title"
+	ArticleIDHash           = "hash"
+	ArticleIDNormalizedLink = "normalized_link"
+)
+
+// ArticleIDHashFields are the article fields that may be used in
+// `article_id_fields` when `article_id` is `hash`.
+var ArticleIDHashFields = []string{"guid", "link", "title", "description", "content", "published", "updated"}
+
 const (
 	NotifierMattermostWebhook = "mattermost_webhook"
 	NotifierPushover          = "pushover"
@@ -68,17 +336,67 @@ type Notifier struct {
 	Settings    NotifierSettings       `koanf:"-"`
 }
 
+// FetchSettings contains the global settings for fetching feeds.
+type FetchSettings struct {
+	Jobs        int      `koanf:"jobs"`
+	HostJobs    int      `koanf:"host_jobs"`
+	HostDelay   int      `koanf:"host_delay"`
+	Interval    Interval `koanf:"interval"`
+	MinInterval Interval `koanf:"min_interval"`
+	MaxInterval Interval `koanf:"max_interval"`
+	MaxBackoff  int      `koanf:"max_backoff"`
+	HistoryDays int      `koanf:"history_days"`
+
+	// Random delays in seconds, to avoid fetching many feeds at once.
+	Jitter        int `koanf:"jitter"`
+	StartupSpread int `koanf:"startup_spread"`
+
+	HTTPSettings `koanf:",squash"`
+}
+
+// AlertSettings contains the thresholds for sending alerts to the admin
+// notifier.
+type AlertSettings struct {
+	Failures         int `koanf:"failures"`
+	Hours            int `koanf:"hours"`
+	NotifierFailures int `koanf:"notifier_failures"`
+}
+
+// NetworkPolicy restricts the addresses that can be connected to when
+// fetching feeds and sending notifications.
+type NetworkPolicy struct {
+	BlockPrivate bool     `koanf:"block_private"`
+	Allow        []string `koanf:"allow"`
+}
+
+// WebSubSettings contains the settings for receiving WebSub (PubSubHubbub)
+// pushes from hubs.
+type WebSubSettings struct {
+	// CallbackURL is the public URL of the callback server. The ID of each
+	// feed is appended to it. WebSub is disabled if it's empty.
+	CallbackURL string `koanf:"callback_url"`
+	Listen      string `koanf:"listen"`
+	LeaseDays   int    `koanf:"lease_days"`
+}
+
+// DefaultUserAgent is the User-Agent sent when fetching feeds if none is
+// configured.
+const DefaultUserAgent = "feed-notifier (+https://github.com/jamielinux/feed-notifier)"
+
 // Config represents the complete configuration for the program.
 type Config struct {
-	Database string `koanf:"database"`
-	Debug    bool   `koanf:"debug"`
-	Fetch    struct {
-		Jobs     int `koanf:"jobs"`
-		Interval int `koanf:"interval"`
-	} `koanf:"fetch"`
-	Notifiers       []Notifier `koanf:"notifiers"`
-	DefaultNotifier string     `koanf:"default_notifier"`
-	Feeds           []Feed     `koanf:"feeds"`
+	Database        string         `koanf:"database"`
+	Debug           bool           `koanf:"debug"`
+	Fetch           FetchSettings  `koanf:"fetch"`
+	Notifiers       []Notifier     `koanf:"notifiers"`
+	DefaultNotifier string         `koanf:"default_notifier"`
+	AdminNotifier   string         `koanf:"admin_notifier"`
+	Alerts          AlertSettings  `koanf:"alerts"`
+	NetworkPolicy   NetworkPolicy  `koanf:"network_policy"`
+	WebSub          WebSubSettings `koanf:"websub"`
+	DedupScope      string         `koanf:"dedup_scope"`
+	Groups          []Group        `koanf:"groups"`
+	Feeds           []Feed         `koanf:"feeds"`
 }
 
 // Load loads the config file and creates a new Config.
@@ -90,7 +408,16 @@ func Load(configPath string) (*Config, error) {
 	}
 
 	var config Config
-	if err := k.Unmarshal("", &config); err != nil {
+	if err := k.UnmarshalWithConf("", &config, koanf.UnmarshalConf{
+		DecoderConfig: &mapstructure.DecoderConfig{
+			DecodeHook: mapstructure.ComposeDecodeHookFunc(
+				intervalHookFunc(),
+				mapstructure.StringToTimeDurationHookFunc(),
+				mapstructure.TextUnmarshallerHookFunc()),
+			Result:           &config,
+			WeaklyTypedInput: true,
+		},
+	}); err != nil {
 		return nil, fmt.Errorf("config error: %v", err)
 	}
 
@@ -115,21 +442,123 @@ func (c *Config) setDefaults() {
 		c.Fetch.Jobs = 3
 	}
 
+	if c.Fetch.HostJobs == 0 {
+		c.Fetch.HostJobs = 1
+	}
+
+	if c.Fetch.HostDelay == 0 {
+		c.Fetch.HostDelay = 1
+	}
+
 	if c.Fetch.Interval == 0 {
-		c.Fetch.Interval = 60
+		c.Fetch.Interval = Interval(60 * time.Minute)
+	}
+
+	if c.Fetch.MinInterval == 0 {
+		c.Fetch.MinInterval = Interval(15 * time.Minute)
+	}
+
+	if c.Fetch.MaxInterval == 0 {
+		c.Fetch.MaxInterval = Interval(24 * time.Hour)
+	}
+
+	if c.Fetch.MaxBackoff == 0 {
+		c.Fetch.MaxBackoff = 360
+	}
+
+	if c.Fetch.HistoryDays == 0 {
+		c.Fetch.HistoryDays = 30
+	}
+
+	if c.Fetch.Timeout == 0 {
+		c.Fetch.Timeout = 30
+	}
+
+	if c.Fetch.MaxBodyBytes == 0 {
+		c.Fetch.MaxBodyBytes = 10 * 1024 * 1024
+	}
+
+	if c.Fetch.UserAgent == "" {
+		c.Fetch.UserAgent = DefaultUserAgent
 	}
 
 	if c.DefaultNotifier == "" {
 		c.DefaultNotifier = "stdout"
 	}
 
+	if c.Alerts.Failures == 0 {
+		c.Alerts.Failures = 5
+	}
+
+	if c.Alerts.NotifierFailures == 0 {
+		c.Alerts.NotifierFailures = 3
+	}
+
+	if c.WebSub.Listen == "" {
+		c.WebSub.Listen = ":8080"
+	}
+
+	if c.WebSub.LeaseDays == 0 {
+		c.WebSub.LeaseDays = 7
+	}
+
+	groups := make(map[string]*Group)
+	for i := range c.Groups {
+		groups[c.Groups[i].ID] = &c.Groups[i]
+	}
+
 	for i := range c.Feeds {
 		feed := &c.Feeds[i]
+		if group, exists := groups[feed.Group]; exists {
+			feed.inherit(&group.FeedSettings)
+			for _, tag := range group.Tags {
+				if !slices.Contains(feed.Tags, tag) {
+					feed.Tags = append(feed.Tags, tag)
+				}
+			}
+		}
+		feed.HTTPSettings.inherit(&c.Fetch.HTTPSettings)
 		if feed.Interval == 0 {
 			feed.Interval = c.Fetch.Interval
 		}
+		if feed.MinInterval == 0 {
+			feed.MinInterval = c.Fetch.MinInterval
+		}
+		if feed.MaxInterval == 0 {
+			feed.MaxInterval = c.Fetch.MaxInterval
+		}
 		if feed.Notifier == "" {
 			feed.Notifier = c.DefaultNotifier
 		}
+		if feed.Type == "" {
+			feed.Type = TypeFeed
+		}
+		setDefault(&feed.MaxAge, 0)
+		// A group can enable websub for feeds that can't use it.
+		if !feed.CanUseWebSub() {
+			feed.WebSub = nil
+		}
+		setDefault(&feed.WebSub, false)
+		setDefault(&feed.MaxPages, 0)
+		setDefault(&feed.InsecureSkipVerify, false)
+		if feed.Order == "" {
+			feed.Order = OrderFeed
+		}
+		if feed.DedupScope == "" {
+			feed.DedupScope = c.DedupScope
+		}
+		if feed.ArticleID == "" {
+			feed.ArticleID = ArticleIDDefault
+		}
+		if feed.ArticleID == ArticleIDHash && len(feed.ArticleIDFields) == 0 {
+			feed.ArticleIDFields = []string{"guid", "link", "title"}
+		}
+	}
+}
+
+// setDefault sets an optional setting to a default value if it's undefined.
+func setDefault[T any](setting **T, value T) {
+	if *setting == nil {
+		*setting = &value
 	}
 }
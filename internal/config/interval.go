@@ -0,0 +1,73 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-viper/mapstructure/v2"
+)
+
+// Interval is the time between fetches of a feed. It's configured as either a
+// number of minutes, a duration string such as "30s" or "1h30m", or "auto".
+type Interval time.Duration
+
+// IntervalAuto means that the interval is adjusted to how often the feed is
+// updated.
+const IntervalAuto Interval = -1
+
+// Duration returns the interval as a time.Duration.
+func (i Interval) Duration() time.Duration {
+	return time.Duration(i)
+}
+
+// String returns the interval as a duration string.
+func (i Interval) String() string {
+	if i == IntervalAuto {
+		return "auto"
+	}
+	return time.Duration(i).String()
+}
+
+// parseInterval parses a number of minutes, a duration string or "auto".
+func parseInterval(s string) (Interval, error) {
+	s = strings.TrimSpace(s)
+	if strings.EqualFold(s, "auto") {
+		return IntervalAuto, nil
+	}
+	if minutes, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return Interval(time.Duration(minutes) * time.Minute), nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid interval '%s': must be a number of minutes or a duration such as '30s'", s)
+	}
+	return Interval(d), nil
+}
+
+// intervalHookFunc returns a mapstructure hook that decodes an Interval from
+// a number of minutes, a duration string or "auto".
+func intervalHookFunc() mapstructure.DecodeHookFuncType {
+	return func(f reflect.Type, t reflect.Type, data interface{}) (interface{}, error) {
+		if t != reflect.TypeOf(Interval(0)) {
+			return data, nil
+		}
+
+		v := reflect.ValueOf(data)
+		switch f.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return Interval(time.Duration(v.Int()) * time.Minute), nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return Interval(time.Duration(v.Uint()) * time.Minute), nil
+		case reflect.Float32, reflect.Float64:
+			return Interval(time.Duration(v.Float() * float64(time.Minute))), nil
+		case reflect.String:
+			return parseInterval(v.String())
+		default:
+			return data, nil
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ParseActiveHours parses a time window such as "08:00-18:00" into the start
+// and end minutes of the day. The end is exclusive, and if it's before the
+// start then the window wraps around midnight.
+func ParseActiveHours(s string) (int, int, error) {
+	startPart, endPart, ok := strings.Cut(s, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid active_hours '%s': must be a range such as '08:00-18:00'", s)
+	}
+
+	start, err := parseTimeOfDay(startPart)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid active_hours '%s': %v", s, err)
+	}
+	end, err := parseTimeOfDay(endPart)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid active_hours '%s': %v", s, err)
+	}
+	if start == end {
+		return 0, 0, fmt.Errorf("invalid active_hours '%s': start and end cannot be equal", s)
+	}
+
+	return start, end, nil
+}
+
+// parseTimeOfDay parses a time such as "08:30" into minutes since midnight.
+// "24:00" is allowed as the end of the day.
+func parseTimeOfDay(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	if s == "24:00" {
+		return 24 * 60, nil
+	}
+
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time '%s'", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
@@ -2,7 +2,14 @@ package config
 
 import (
 	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/andybalholm/cascadia"
+	"github.com/jamielinux/feed-notifier/internal/cron"
+	"github.com/jamielinux/feed-notifier/internal/jsonpath"
 	"github.com/knadh/koanf/providers/confmap"
 	"github.com/knadh/koanf/v2"
 )
@@ -13,6 +20,14 @@ func (c *Config) Validate() error {
 		return err
 	}
 
+	if err := c.validateNetworkPolicy(); err != nil {
+		return err
+	}
+
+	if err := c.validateWebSub(); err != nil {
+		return err
+	}
+
 	notifierIDs, err := c.validateNotifiers()
 	if err != nil {
 		return err
@@ -22,7 +37,16 @@ func (c *Config) Validate() error {
 		return err
 	}
 
-	if err := c.validateFeeds(notifierIDs); err != nil {
+	if err := c.validateAdminNotifier(notifierIDs); err != nil {
+		return err
+	}
+
+	groupIDs, err := c.validateGroups(notifierIDs)
+	if err != nil {
+		return err
+	}
+
+	if err := c.validateFeeds(notifierIDs, groupIDs); err != nil {
 		return err
 	}
 
@@ -36,9 +60,113 @@ func (c *Config) validateFetch() error {
 	if c.Fetch.Jobs > 10 {
 		return fmt.Errorf("fetch.jobs cannot be greater than 10")
 	}
-	if c.Fetch.Interval <= 0 {
+	if c.Fetch.HostJobs < 0 {
+		return fmt.Errorf("fetch.host_jobs cannot be negative")
+	}
+	if c.Fetch.HostJobs > 10 {
+		return fmt.Errorf("fetch.host_jobs cannot be greater than 10")
+	}
+	if c.Fetch.HostDelay < 0 {
+		return fmt.Errorf("fetch.host_delay cannot be negative")
+	}
+	if c.Fetch.Interval <= 0 && c.Fetch.Interval != IntervalAuto {
 		return fmt.Errorf("fetch.interval cannot be negative")
 	}
+	if c.Fetch.Interval != IntervalAuto && c.Fetch.Interval.Duration() < time.Second {
+		return fmt.Errorf("fetch.interval cannot be less than 1s")
+	}
+	if c.Fetch.MinInterval < 0 {
+		return fmt.Errorf("fetch.min_interval cannot be negative")
+	}
+	if c.Fetch.MinInterval > 0 && c.Fetch.MinInterval.Duration() < time.Second {
+		return fmt.Errorf("fetch.min_interval cannot be less than 1s")
+	}
+	if c.Fetch.MaxInterval < 0 {
+		return fmt.Errorf("fetch.max_interval cannot be negative")
+	}
+	if c.Fetch.MinInterval > 0 && c.Fetch.MaxInterval > 0 && c.Fetch.MinInterval > c.Fetch.MaxInterval {
+		return fmt.Errorf("fetch.min_interval cannot be greater than fetch.max_interval")
+	}
+	if c.Fetch.MaxBackoff < 0 {
+		return fmt.Errorf("fetch.max_backoff cannot be negative")
+	}
+	if c.Fetch.HistoryDays < 0 {
+		return fmt.Errorf("fetch.history_days cannot be negative")
+	}
+	if c.Fetch.Jitter < 0 {
+		return fmt.Errorf("fetch.jitter cannot be negative")
+	}
+	if c.Fetch.StartupSpread < 0 {
+		return fmt.Errorf("fetch.startup_spread cannot be negative")
+	}
+	if err := validateHTTPSettings(&c.Fetch.HTTPSettings, "fetch"); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *Config) validateNetworkPolicy() error {
+	for _, entry := range c.NetworkPolicy.Allow {
+		if strings.TrimSpace(entry) == "" {
+			return fmt.Errorf("network_policy.allow cannot contain an empty entry")
+		}
+	}
+
+	if !c.NetworkPolicy.BlockPrivate {
+		return nil
+	}
+
+	// Only the connection to a proxy can be checked, not the connections
+	// that it makes, so a proxy would bypass the policy.
+	if usesProxy(&c.Fetch.HTTPSettings) {
+		return fmt.Errorf("fetch.proxy cannot be used with network_policy.block_private")
+	}
+	for _, group := range c.Groups {
+		if usesProxy(&group.HTTPSettings) {
+			return fmt.Errorf("proxy cannot be used with network_policy.block_private for group '%s'", group.ID)
+		}
+	}
+	for _, feed := range c.Feeds {
+		if usesProxy(&feed.HTTPSettings) {
+			return fmt.Errorf("proxy cannot be used with network_policy.block_private for feed '%s'", feed.ID)
+		}
+	}
+
+	return nil
+}
+
+// usesProxy returns true if HTTP settings configure a proxy.
+func usesProxy(h *HTTPSettings) bool {
+	return h.Proxy != "" && h.Proxy != ProxyDirect
+}
+
+func (c *Config) validateWebSub() error {
+	if c.WebSub.CallbackURL != "" {
+		u, err := url.Parse(c.WebSub.CallbackURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("websub.callback_url must be an absolute http or https URL")
+		}
+		if u.RawQuery != "" || u.Fragment != "" {
+			return fmt.Errorf("websub.callback_url cannot have a query or fragment")
+		}
+	}
+	if c.WebSub.LeaseDays < 0 {
+		return fmt.Errorf("websub.lease_days cannot be negative")
+	}
+
+	if c.WebSub.CallbackURL == "" {
+		for _, group := range c.Groups {
+			if group.WebSub != nil && *group.WebSub {
+				return fmt.Errorf("websub is enabled for group '%s' but websub.callback_url is not set", group.ID)
+			}
+		}
+		for _, feed := range c.Feeds {
+			if feed.WebSub != nil && *feed.WebSub {
+				return fmt.Errorf("websub is enabled for feed '%s' but websub.callback_url is not set", feed.ID)
+			}
+		}
+	}
+
 	return nil
 }
 
@@ -114,7 +242,49 @@ func (c *Config) validateDefaultNotifier(notifierIDs map[string]bool) error {
 	return nil
 }
 
-func (c *Config) validateFeeds(notifierIDs map[string]bool) error {
+func (c *Config) validateAdminNotifier(notifierIDs map[string]bool) error {
+	if c.Alerts.Failures < 0 {
+		return fmt.Errorf("alerts.failures cannot be negative")
+	}
+	if c.Alerts.Hours < 0 {
+		return fmt.Errorf("alerts.hours cannot be negative")
+	}
+	if c.Alerts.NotifierFailures < 0 {
+		return fmt.Errorf("alerts.notifier_failures cannot be negative")
+	}
+	if c.AdminNotifier == "" {
+		return nil
+	}
+	if _, exists := notifierIDs[c.AdminNotifier]; !exists {
+		return fmt.Errorf("admin_notifier '%s' does not match any notifiers", c.AdminNotifier)
+	}
+	return nil
+}
+
+func (c *Config) validateGroups(notifierIDs map[string]bool) (map[string]bool, error) {
+	groupIDs := make(map[string]bool)
+
+	for i := range c.Groups {
+		group := &c.Groups[i]
+
+		if group.ID == "" {
+			return nil, fmt.Errorf("groups must have an id")
+		}
+
+		if _, exists := groupIDs[group.ID]; exists {
+			return nil, fmt.Errorf("duplicate group id '%s'", group.ID)
+		}
+		groupIDs[group.ID] = true
+
+		if err := validateFeedSettings(&group.FeedSettings, fmt.Sprintf("group '%s'", group.ID), notifierIDs); err != nil {
+			return nil, err
+		}
+	}
+
+	return groupIDs, nil
+}
+
+func (c *Config) validateFeeds(notifierIDs map[string]bool, groupIDs map[string]bool) error {
 	feedIDs := make(map[string]bool)
 
 	for i := range c.Feeds {
@@ -133,19 +303,286 @@ func (c *Config) validateFeeds(notifierIDs map[string]bool) error {
 		}
 		feedIDs[feed.ID] = true
 
-		if feed.URL == "" {
-			return fmt.Errorf("url must be defined for feed '%s'", feed.ID)
+		if err := validateSource(feed); err != nil {
+			return err
 		}
 
-		if feed.Interval < 0 {
-			return fmt.Errorf("interval cannot be negative for feed '%s'", feed.ID)
+		if feed.Group != "" {
+			if _, exists := groupIDs[feed.Group]; !exists {
+				return fmt.Errorf("group '%s' for feed '%s' does not match any groups", feed.Group, feed.ID)
+			}
 		}
 
-		if feed.Notifier != "" {
-			if _, exists := notifierIDs[feed.Notifier]; !exists {
-				return fmt.Errorf("notifier '%s' for feed '%s' does not match any notifiers", feed.Notifier, feed.ID)
+		if err := validateFeedType(feed); err != nil {
+			return err
+		}
+
+		if feed.WebSub != nil && *feed.WebSub && !feed.CanUseWebSub() {
+			return fmt.Errorf("websub can only be used with a http or https url and type '%s' for feed '%s'",
+				TypeFeed, feed.ID)
+		}
+
+		if err := validateFeedSettings(&feed.FeedSettings, fmt.Sprintf("feed '%s'", feed.ID), notifierIDs); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateSource validates the url or command of a feed.
+func validateSource(feed *Feed) error {
+	if feed.URL == "" && len(feed.Command) == 0 {
+		return fmt.Errorf("url or command must be defined for feed '%s'", feed.ID)
+	}
+	if feed.URL != "" && len(feed.Command) > 0 {
+		return fmt.Errorf("url and command cannot both be defined for feed '%s'", feed.ID)
+	}
+	if len(feed.Command) > 0 && feed.Command[0] == "" {
+		return fmt.Errorf("command cannot be empty for feed '%s'", feed.ID)
+	}
+	// Scraped and JSON articles often have relative links, which can't be
+	// resolved without a URL.
+	if len(feed.Command) > 0 && (feed.Type == TypeScrape || feed.Type == TypeJSON) {
+		return fmt.Errorf("type '%s' cannot be used with command for feed '%s'", feed.Type, feed.ID)
+	}
+
+	if feed.URL != "" && feed.IsLocal() {
+		u, err := url.Parse(feed.URL)
+		if err != nil || (u.Host != "" && u.Host != "localhost") || !strings.HasPrefix(u.Path, "/") {
+			return fmt.Errorf("url must be an absolute file:///path for feed '%s'", feed.ID)
+		}
+	}
+
+	return nil
+}
+
+// validateFeedType validates the type of a feed and its settings.
+func validateFeedType(feed *Feed) error {
+	switch feed.Type {
+	case "", TypeFeed:
+		return nil
+	case TypeScrape:
+		return validateScrape(&feed.Scrape, feed.ID)
+	case TypeJSON:
+		return validateJSON(&feed.JSON, feed.ID)
+	default:
+		return fmt.Errorf("type '%s' is invalid for feed '%s'", feed.Type, feed.ID)
+	}
+}
+
+func validateScrape(s *ScrapeSettings, feedID string) error {
+	if s.Items == "" {
+		return fmt.Errorf("scrape.items must be defined for feed '%s'", feedID)
+	}
+	if s.Title == "" {
+		return fmt.Errorf("scrape.title must be defined for feed '%s'", feedID)
+	}
+
+	selectors := []struct{ name, value string }{
+		{"items", s.Items},
+		{"title", s.Title},
+		{"link", s.Link},
+		{"date", s.Date},
+		{"content", s.Content},
+	}
+	for _, selector := range selectors {
+		if selector.value == "" {
+			continue
+		}
+		if _, err := cascadia.Compile(selector.value); err != nil {
+			return fmt.Errorf("scrape.%s selector is invalid for feed '%s': %v", selector.name, feedID, err)
+		}
+	}
+
+	if s.DateFormat != "" && s.Date == "" {
+		return fmt.Errorf("scrape.date_format requires scrape.date for feed '%s'", feedID)
+	}
+
+	return nil
+}
+
+func validateJSON(s *JSONSettings, feedID string) error {
+	if s.Title == "" {
+		return fmt.Errorf("json.title must be defined for feed '%s'", feedID)
+	}
+
+	paths := []struct{ name, value string }{
+		{"items", s.Items},
+		{"id", s.ID},
+		{"title", s.Title},
+		{"link", s.Link},
+		{"date", s.Date},
+		{"content", s.Content},
+	}
+	for _, path := range paths {
+		if _, err := jsonpath.Parse(path.value); err != nil {
+			return fmt.Errorf("json.%s is invalid for feed '%s': %v", path.name, feedID, err)
+		}
+	}
+
+	if s.DateFormat != "" && s.Date == "" {
+		return fmt.Errorf("json.date_format requires json.date for feed '%s'", feedID)
+	}
+
+	return nil
+}
+
+// validateFeedSettings validates settings that can be defined in both feeds
+// and groups. The owner describes where the settings are defined.
+func validateFeedSettings(s *FeedSettings, owner string, notifierIDs map[string]bool) error {
+	if s.Interval < 0 && s.Interval != IntervalAuto {
+		return fmt.Errorf("interval cannot be negative for %s", owner)
+	}
+	if s.Interval > 0 && s.Interval.Duration() < time.Second {
+		return fmt.Errorf("interval cannot be less than 1s for %s", owner)
+	}
+	if s.MinInterval < 0 {
+		return fmt.Errorf("min_interval cannot be negative for %s", owner)
+	}
+	if s.MinInterval > 0 && s.MinInterval.Duration() < time.Second {
+		return fmt.Errorf("min_interval cannot be less than 1s for %s", owner)
+	}
+	if s.MaxInterval < 0 {
+		return fmt.Errorf("max_interval cannot be negative for %s", owner)
+	}
+	if s.MinInterval > 0 && s.MaxInterval > 0 && s.MinInterval > s.MaxInterval {
+		return fmt.Errorf("min_interval cannot be greater than max_interval for %s", owner)
+	}
+
+	if s.MaxAge != nil && *s.MaxAge < 0 {
+		return fmt.Errorf("max_age cannot be negative for %s", owner)
+	}
+
+	if s.MaxPages != nil && *s.MaxPages < 0 {
+		return fmt.Errorf("max_pages cannot be negative for %s", owner)
+	}
+
+	if err := validateSchedule(s, owner); err != nil {
+		return err
+	}
+
+	switch s.Order {
+	case "", OrderFeed, OrderChronological:
+	default:
+		return fmt.Errorf("order '%s' is invalid for %s", s.Order, owner)
+	}
+
+	if err := validateArticleID(s, owner); err != nil {
+		return err
+	}
+
+	if err := validateHTTPSettings(&s.HTTPSettings, owner); err != nil {
+		return err
+	}
+
+	if s.Notifier != "" {
+		if _, exists := notifierIDs[s.Notifier]; !exists {
+			return fmt.Errorf("notifier '%s' for %s does not match any notifiers", s.Notifier, owner)
+		}
+	}
+
+	return nil
+}
+
+func validateSchedule(s *FeedSettings, owner string) error {
+	loc := time.Local
+	if s.Timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(s.Timezone); err != nil {
+			return fmt.Errorf("timezone '%s' is invalid for %s: %v", s.Timezone, owner, err)
+		}
+	}
+
+	if len(s.Schedule) > 0 && s.Interval != 0 {
+		return fmt.Errorf("schedule and interval cannot both be defined for %s", owner)
+	}
+
+	for _, expr := range s.Schedule {
+		schedule, err := cron.Parse(expr)
+		if err != nil {
+			return fmt.Errorf("schedule is invalid for %s: %v", owner, err)
+		}
+		if schedule.Next(time.Now().In(loc)).IsZero() {
+			return fmt.Errorf("schedule '%s' never matches for %s", expr, owner)
+		}
+	}
+
+	if s.ActiveHours != "" {
+		if _, _, err := ParseActiveHours(s.ActiveHours); err != nil {
+			return fmt.Errorf("%v for %s", err, owner)
+		}
+	}
+
+	for _, day := range s.ActiveDays {
+		if _, ok := cron.ParseWeekday(day); !ok {
+			return fmt.Errorf("active_days contains invalid day '%s' for %s", day, owner)
+		}
+	}
+
+	return nil
+}
+
+func validateArticleID(s *FeedSettings, owner string) error {
+	switch s.ArticleID {
+	case "", ArticleIDDefault, ArticleIDGUID, ArticleIDLink, ArticleIDTitle, ArticleIDNormalizedLink:
+		if len(s.ArticleIDFields) > 0 {
+			return fmt.Errorf("article_id_fields requires article_id 'hash' for %s", owner)
+		}
+	case ArticleIDHash:
+		for _, field := range s.ArticleIDFields {
+			if !slices.Contains(ArticleIDHashFields, field) {
+				return fmt.Errorf("article_id_fields contains invalid field '%s' for %s", field, owner)
 			}
 		}
+	default:
+		return fmt.Errorf("article_id '%s' is invalid for %s", s.ArticleID, owner)
+	}
+
+	if len(s.ArticleIDStripParams) > 0 && s.ArticleID != ArticleIDNormalizedLink {
+		return fmt.Errorf("article_id_strip_params requires article_id 'normalized_link' for %s", owner)
+	}
+
+	return nil
+}
+
+func validateHTTPSettings(h *HTTPSettings, owner string) error {
+	if h.BasicAuth.Password != "" && h.BasicAuth.Username == "" {
+		return fmt.Errorf("basic_auth.username must be defined for %s", owner)
+	}
+
+	if h.BasicAuth.Username != "" && h.BearerToken != "" {
+		return fmt.Errorf("basic_auth and bearer_token cannot both be defined for %s", owner)
+	}
+
+	if h.Proxy != "" && h.Proxy != ProxyDirect {
+		u, err := url.Parse(h.Proxy)
+		if err != nil {
+			return fmt.Errorf("proxy '%s' is invalid for %s: %v", h.Proxy, owner, err)
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return fmt.Errorf("proxy '%s' for %s must use http, https, socks5 or socks5h", h.Proxy, owner)
+		}
+	}
+
+	if h.Timeout < 0 {
+		return fmt.Errorf("timeout cannot be negative for %s", owner)
+	}
+
+	if h.MaxBodyBytes < 0 {
+		return fmt.Errorf("max_body_bytes cannot be negative for %s", owner)
+	}
+
+	if (h.ClientCert == "") != (h.ClientKey == "") {
+		return fmt.Errorf("client_cert and client_key must both be defined for %s", owner)
+	}
+
+	for name := range h.Headers {
+		if name == "" || strings.ContainsAny(name, " :\r\n") {
+			return fmt.Errorf("header '%s' is invalid for %s", name, owner)
+		}
 	}
 
 	return nil
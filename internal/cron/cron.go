@@ -0,0 +1,222 @@
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron expression.
+type Schedule struct {
+	minutes  uint64 // bits 0-59
+	hours    uint64 // bits 0-23
+	days     uint64 // bits 1-31
+	months   uint64 // bits 1-12
+	weekdays uint64 // bits 0-6, Sunday is 0
+
+	// When both the day of month and day of week are restricted, a time
+	// matches if either of them match, like the standard cron.
+	daysRestricted     bool
+	weekdaysRestricted bool
+}
+
+// field describes one of the fields of a cron expression.
+type field struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minuteField  = field{name: "minute", min: 0, max: 59}
+	hourField    = field{name: "hour", min: 0, max: 23}
+	dayField     = field{name: "day of month", min: 1, max: 31}
+	monthField   = field{name: "month", min: 1, max: 12, names: monthNames}
+	weekdayField = field{name: "day of week", min: 0, max: 7, names: weekdayNames}
+)
+
+var monthNames = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var weekdayNames = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseWeekday parses the name of a day of the week, such as "mon".
+func ParseWeekday(name string) (time.Weekday, bool) {
+	day, ok := weekdayNames[strings.ToLower(name)]
+	return time.Weekday(day), ok
+}
+
+// Parse parses a standard five field cron expression (minute, hour, day of
+// month, month and day of week) or one of the macros such as @daily. Fields
+// can contain lists, ranges, steps and names, eg "*/5 8-18 * * MON-FRI".
+func Parse(expr string) (*Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if macro, ok := macros[strings.ToLower(expr)]; ok {
+		expr = macro
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression '%s' must have 5 fields", expr)
+	}
+
+	s := &Schedule{}
+	var err error
+
+	if s.minutes, _, err = parseField(fields[0], minuteField); err != nil {
+		return nil, err
+	}
+	if s.hours, _, err = parseField(fields[1], hourField); err != nil {
+		return nil, err
+	}
+	if s.days, s.daysRestricted, err = parseField(fields[2], dayField); err != nil {
+		return nil, err
+	}
+	if s.months, _, err = parseField(fields[3], monthField); err != nil {
+		return nil, err
+	}
+	if s.weekdays, s.weekdaysRestricted, err = parseField(fields[4], weekdayField); err != nil {
+		return nil, err
+	}
+
+	// 7 is an alias for Sunday.
+	if s.weekdays&(1<<7) != 0 {
+		s.weekdays = s.weekdays&^(1<<7) | 1
+	}
+
+	return s, nil
+}
+
+// parseField parses a single field into a bitmask of the allowed values. It
+// also returns whether the field is restricted, ie isn't "*".
+func parseField(value string, f field) (uint64, bool, error) {
+	var bits uint64
+	restricted := true
+
+	for _, part := range strings.Split(value, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step <= 0 {
+				return 0, false, fmt.Errorf("invalid step '%s' in %s field", stepPart, f.name)
+			}
+		}
+
+		var low, high int
+		switch {
+		case rangePart == "*":
+			low, high = f.min, f.max
+			if f.max == 7 {
+				high = 6
+			}
+			if !hasStep {
+				restricted = false
+			}
+		case strings.Contains(rangePart, "-"):
+			lowPart, highPart, _ := strings.Cut(rangePart, "-")
+			var err error
+			if low, err = parseValue(lowPart, f); err != nil {
+				return 0, false, err
+			}
+			if high, err = parseValue(highPart, f); err != nil {
+				return 0, false, err
+			}
+			// Sunday is 7 at the end of a range of weekdays, eg "MON-SUN".
+			if f.max == 7 && high == 0 && low > 0 {
+				high = 7
+			}
+			if low > high {
+				return 0, false, fmt.Errorf("invalid range '%s' in %s field", rangePart, f.name)
+			}
+		default:
+			var err error
+			if low, err = parseValue(rangePart, f); err != nil {
+				return 0, false, err
+			}
+			high = low
+			if hasStep {
+				high = f.max
+			}
+		}
+
+		for i := low; i <= high; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+
+	return bits, restricted, nil
+}
+
+// parseValue parses a number or name in a field.
+func parseValue(value string, f field) (int, error) {
+	if n, ok := f.names[strings.ToLower(value)]; ok {
+		return n, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < f.min || n > f.max {
+		return 0, fmt.Errorf("invalid value '%s' in %s field", value, f.name)
+	}
+	return n, nil
+}
+
+// Next returns the first time after t that matches the schedule, in the
+// location of t. It returns the zero time if there's no match within five
+// years, eg for "0 0 30 2 *".
+func (s *Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.months&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !s.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if s.hours&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if s.minutes&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+
+	return time.Time{}
+}
+
+// matchesDay reports whether the day of t matches the day of month and day of
+// week fields.
+func (s *Schedule) matchesDay(t time.Time) bool {
+	dayMatch := s.days&(1<<uint(t.Day())) != 0
+	weekdayMatch := s.weekdays&(1<<uint(t.Weekday())) != 0
+
+	if s.daysRestricted && s.weekdaysRestricted {
+		return dayMatch || weekdayMatch
+	}
+	return dayMatch && weekdayMatch
+}
@@ -0,0 +1,137 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNext(t *testing.T) {
+	// A Wednesday.
+	wed := time.Date(2026, 10, 14, 10, 7, 0, 0, time.UTC)
+
+	tests := []struct {
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		// Ranges, lists and steps.
+		{"*/15 * * * *", wed, time.Date(2026, 10, 14, 10, 15, 0, 0, time.UTC)},
+		{"5/20 * * * *", wed, time.Date(2026, 10, 14, 10, 25, 0, 0, time.UTC)},
+		{"0 9-17 * * *", wed, time.Date(2026, 10, 14, 11, 0, 0, 0, time.UTC)},
+		{"30 8 * * *", wed, time.Date(2026, 10, 15, 8, 30, 0, 0, time.UTC)},
+		{"0 8,12,16 * * *", wed, time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", wed, time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 12 29 2 *", wed, time.Date(2028, 2, 29, 12, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", wed, time.Time{}},
+
+		// Days of the week, where both 0 and 7 are Sunday.
+		{"0 9 * * MON-FRI", wed, time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)},
+		{"0 22 * * 1-5/2", wed, time.Date(2026, 10, 14, 22, 0, 0, 0, time.UTC)},
+		{"0 9 * * sat,sun", wed, time.Date(2026, 10, 17, 9, 0, 0, 0, time.UTC)},
+		{"0 9 * * 0", wed, time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)},
+		{"0 9 * * 7", wed, time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)},
+		{"0 9 * * 6-7", wed.AddDate(0, 0, 3), time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)},
+		{"0 9 * * FRI-SUN", wed.AddDate(0, 0, 3), time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)},
+		{"0 9 * * MON-SUN", wed, time.Date(2026, 10, 15, 9, 0, 0, 0, time.UTC)},
+		{"0 0 * * */2", wed, time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC)},
+
+		// Names of months.
+		{"0 0 1 jan *", wed, time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 JUN-AUG *", wed, time.Date(2027, 6, 1, 0, 0, 0, 0, time.UTC)},
+
+		// If both the day of month and day of week are restricted, either
+		// can match.
+		{"0 0 20 * MON", wed, time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)},
+		{"0 0 20 * MON", wed.AddDate(0, 0, 5), time.Date(2026, 10, 20, 0, 0, 0, 0, time.UTC)},
+		{"0 0 20 * *", wed, time.Date(2026, 10, 20, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * 11 MON", wed, time.Date(2026, 11, 2, 0, 0, 0, 0, time.UTC)},
+
+		// Macros.
+		{"@hourly", wed, time.Date(2026, 10, 14, 11, 0, 0, 0, time.UTC)},
+		{"@daily", wed, time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC)},
+		{"@midnight", wed, time.Date(2026, 10, 15, 0, 0, 0, 0, time.UTC)},
+		{"@weekly", wed, time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", wed, time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", wed, time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"@ANNUALLY", wed, time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			schedule, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("Parse(%q) failed: %v", tt.expr, err)
+			}
+			if got := schedule.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("Next(%s) = %s, want %s", tt.from, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextLocation(t *testing.T) {
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Skip("time zone data isn't available")
+	}
+
+	schedule, err := Parse("0 9 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The day after the clocks go back, 09:00 in London is 09:00 UTC.
+	from := time.Date(2026, 10, 25, 12, 0, 0, 0, london)
+	want := time.Date(2026, 10, 26, 9, 0, 0, 0, time.UTC)
+	if got := schedule.Next(from); !got.Equal(want) || got.Location() != london {
+		t.Errorf("Next(%s) = %s, want %s in Europe/London", from, got, want)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"@reboot",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"* * * * FRI-MON",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"* * * * foo",
+		"* * * dec-jan *",
+	}
+
+	for _, expr := range tests {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestParseWeekday(t *testing.T) {
+	tests := []struct {
+		name string
+		want time.Weekday
+		ok   bool
+	}{
+		{"sun", time.Sunday, true},
+		{"MON", time.Monday, true},
+		{"Sat", time.Saturday, true},
+		{"monday", 0, false},
+		{"7", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := ParseWeekday(tt.name)
+		if ok != tt.ok || (ok && got != tt.want) {
+			t.Errorf("ParseWeekday(%q) = %s, %t, want %s, %t", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
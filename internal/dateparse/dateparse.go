@@ -0,0 +1,41 @@
+package dateparse
+
+import "time"
+
+// layouts are tried in order when no layout is given.
+var layouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"January 2, 2006 15:04",
+	"January 2, 2006",
+	"Jan 2, 2006",
+	"2 January 2006",
+	"2 Jan 2006",
+}
+
+// Parse parses a date with the given Go time layout, or with common layouts
+// if layout is empty. Dates without a time zone are in loc. It returns nil if
+// the date can't be parsed.
+func Parse(value, layout string, loc *time.Location) *time.Time {
+	if value == "" {
+		return nil
+	}
+
+	candidates := layouts
+	if layout != "" {
+		candidates = []string{layout}
+	}
+
+	for _, candidate := range candidates {
+		if t, err := time.ParseInLocation(candidate, value, loc); err == nil {
+			return &t
+		}
+	}
+	return nil
+}
@@ -16,6 +16,31 @@ type Feed struct {
 	LastModified string `db:"last_modified"`
 	MaxAge       int64  `db:"max_age"`
 	LastChecked  int64  `db:"last_checked"`
+	NotBefore    int64  `db:"not_before"`
+	Failures     int    `db:"failures"`
+
+	// RedirectURL is where RedirectSource (the configured URL of the feed)
+	// has permanently moved to.
+	RedirectSource string `db:"redirect_source"`
+	RedirectURL    string `db:"redirect_url"`
+
+	// GoneURL is the configured URL of the feed if it returned 410 Gone.
+	GoneURL string `db:"gone_url"`
+
+	// FailingSince is when the current run of consecutive failures started,
+	// and Alerted is whether an alert has been sent about it.
+	FailingSince int64 `db:"failing_since"`
+	Alerted      bool  `db:"alerted"`
+
+	// ContentHash is a hash of the body of the last successful response.
+	ContentHash string `db:"content_hash"`
+
+	// TTL is the minimum time in seconds between fetches that the feed
+	// itself asks for. SkipHours (0-23, in UTC) and SkipDays (eg "Monday")
+	// are comma-separated lists of when the feed asks not to be fetched.
+	TTL       int64  `db:"ttl"`
+	SkipHours string `db:"skip_hours"`
+	SkipDays  string `db:"skip_days"`
 }
 
 // Article represents an article in a feed.
@@ -24,6 +49,31 @@ type Article struct {
 	ArticleID string `db:"article_id"`
 }
 
+// Fetch records an attempt to fetch a feed.
+type Fetch struct {
+	FeedID     string `db:"feed_id"`
+	Timestamp  int64  `db:"timestamp"`
+	DurationMs int64  `db:"duration_ms"`
+	HTTPStatus int    `db:"http_status"`
+	Bytes      int64  `db:"bytes"`
+	Items      int    `db:"items"`
+	NewItems   int    `db:"new_items"`
+	Error      string `db:"error"`
+}
+
+// Subscription is a WebSub subscription to a feed's hub.
+type Subscription struct {
+	FeedID string `db:"feed_id"`
+	Hub    string `db:"hub"`
+	Topic  string `db:"topic"`
+	Secret string `db:"secret"`
+
+	// Requested is when the subscription was last requested, and Expires is
+	// when the lease ends. Expires is 0 until the hub has verified it.
+	Requested int64 `db:"requested"`
+	Expires   int64 `db:"expires"`
+}
+
 // DB holds the database information.
 type DB struct {
 	*sql.DB
@@ -53,10 +103,87 @@ func Open(dbFile string) (*DB, error) {
 		    article_id TEXT NOT NULL,
 		    PRIMARY KEY(feed_id, article_id)
 		);
+		CREATE TABLE IF NOT EXISTS dedup (
+		    scope TEXT NOT NULL,
+		    notifier TEXT NOT NULL,
+		    article_id TEXT NOT NULL,
+		    PRIMARY KEY(scope, notifier, article_id)
+		);
 	`); err != nil {
 		return nil, fmt.Errorf("failed to create tables: %v", err)
 	}
 
+	if err := migrate(db); err != nil {
+		return nil, fmt.Errorf("failed to migrate database: %v", err)
+	}
+
 	d := &DB{DB: db}
 	return d, nil
 }
+
+// migrations upgrade the schema of an existing database. They are applied in
+// order, and the number of applied migrations is stored in the user_version
+// pragma. Only append to this list.
+var migrations = []string{
+	`ALTER TABLE feeds ADD COLUMN not_before INTEGER NOT NULL DEFAULT 0;
+	 ALTER TABLE feeds ADD COLUMN failures INTEGER NOT NULL DEFAULT 0;`,
+	`ALTER TABLE feeds ADD COLUMN redirect_source TEXT NOT NULL DEFAULT '';
+	 ALTER TABLE feeds ADD COLUMN redirect_url TEXT NOT NULL DEFAULT '';
+	 ALTER TABLE feeds ADD COLUMN gone_url TEXT NOT NULL DEFAULT '';`,
+	`ALTER TABLE feeds ADD COLUMN failing_since INTEGER NOT NULL DEFAULT 0;
+	 ALTER TABLE feeds ADD COLUMN alerted INTEGER NOT NULL DEFAULT 0;`,
+	`CREATE TABLE fetches (
+	     feed_id TEXT NOT NULL,
+	     timestamp INTEGER NOT NULL,
+	     duration_ms INTEGER NOT NULL,
+	     http_status INTEGER NOT NULL,
+	     bytes INTEGER NOT NULL,
+	     items INTEGER NOT NULL,
+	     new_items INTEGER NOT NULL,
+	     error TEXT NOT NULL
+	 );
+	 CREATE INDEX fetches_feed_id_timestamp ON fetches (feed_id, timestamp);
+	 CREATE INDEX fetches_timestamp ON fetches (timestamp);`,
+	`ALTER TABLE feeds ADD COLUMN content_hash TEXT NOT NULL DEFAULT '';`,
+	`ALTER TABLE feeds ADD COLUMN ttl INTEGER NOT NULL DEFAULT 0;
+	 ALTER TABLE feeds ADD COLUMN skip_hours TEXT NOT NULL DEFAULT '';
+	 ALTER TABLE feeds ADD COLUMN skip_days TEXT NOT NULL DEFAULT '';
+	 ALTER TABLE articles ADD COLUMN published INTEGER NOT NULL DEFAULT 0;
+	 CREATE INDEX articles_feed_id_published ON articles (feed_id, published);`,
+	`CREATE TABLE websub (
+	     feed_id TEXT NOT NULL PRIMARY KEY,
+	     hub TEXT NOT NULL,
+	     topic TEXT NOT NULL,
+	     secret TEXT NOT NULL,
+	     requested INTEGER NOT NULL,
+	     expires INTEGER NOT NULL
+	 );`,
+}
+
+// migrate applies any migrations that haven't been applied yet.
+func migrate(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %v", i+1, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %v", i+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("migration %d: %v", i+1, err)
+		}
+	}
+
+	return nil
+}
@@ -10,8 +10,17 @@ import (
 // GetFeed retrieves the metadata for a feed.
 func (db *DB) GetFeed(feedID string) *Feed {
 	var metadata Feed
-	row := db.QueryRow("SELECT feed_id, etag, last_modified, max_age, last_checked FROM feeds WHERE feed_id = ?", feedID)
-	err := row.Scan(&metadata.FeedID, &metadata.ETag, &metadata.LastModified, &metadata.MaxAge, &metadata.LastChecked)
+	row := db.QueryRow(`
+        SELECT feed_id, etag, last_modified, max_age, last_checked, not_before, failures,
+            redirect_source, redirect_url, gone_url, failing_since, alerted, content_hash,
+            ttl, skip_hours, skip_days
+        FROM feeds WHERE feed_id = ?
+    `, feedID)
+	err := row.Scan(&metadata.FeedID, &metadata.ETag, &metadata.LastModified, &metadata.MaxAge,
+		&metadata.LastChecked, &metadata.NotBefore, &metadata.Failures,
+		&metadata.RedirectSource, &metadata.RedirectURL, &metadata.GoneURL,
+		&metadata.FailingSince, &metadata.Alerted, &metadata.ContentHash,
+		&metadata.TTL, &metadata.SkipHours, &metadata.SkipDays)
 	if err == sql.ErrNoRows {
 		return nil
 	}
@@ -24,14 +33,31 @@ func (db *DB) GetFeed(feedID string) *Feed {
 // UpdateFeed updates the metadata for a feed.
 func (db *DB) UpdateFeed(metadata *Feed) {
 	_, err := db.Exec(`
-        INSERT INTO feeds (feed_id, etag, last_modified, max_age, last_checked) 
-        VALUES (?, ?, ?, ?, ?)
+        INSERT INTO feeds (feed_id, etag, last_modified, max_age, last_checked, not_before, failures,
+            redirect_source, redirect_url, gone_url, failing_since, alerted, content_hash,
+            ttl, skip_hours, skip_days)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
         ON CONFLICT(feed_id) DO UPDATE SET
             etag = excluded.etag,
             last_modified = excluded.last_modified,
             max_age = excluded.max_age,
-            last_checked = excluded.last_checked
-    `, metadata.FeedID, metadata.ETag, metadata.LastModified, metadata.MaxAge, metadata.LastChecked)
+            last_checked = excluded.last_checked,
+            not_before = excluded.not_before,
+            failures = excluded.failures,
+            redirect_source = excluded.redirect_source,
+            redirect_url = excluded.redirect_url,
+            gone_url = excluded.gone_url,
+            failing_since = excluded.failing_since,
+            alerted = excluded.alerted,
+            content_hash = excluded.content_hash,
+            ttl = excluded.ttl,
+            skip_hours = excluded.skip_hours,
+            skip_days = excluded.skip_days
+    `, metadata.FeedID, metadata.ETag, metadata.LastModified, metadata.MaxAge, metadata.LastChecked,
+		metadata.NotBefore, metadata.Failures,
+		metadata.RedirectSource, metadata.RedirectURL, metadata.GoneURL,
+		metadata.FailingSince, metadata.Alerted, metadata.ContentHash,
+		metadata.TTL, metadata.SkipHours, metadata.SkipDays)
 
 	if err != nil {
 		log.Fatalf("failed to write to database: %v", err)
@@ -58,12 +84,165 @@ func (db *DB) IsArticleNew(feedID string, articleID string) bool {
 }
 
 // LogArticle logs an article after we've processed it and sent a notification.
-func (db *DB) LogArticle(feedID string, articleID string) {
+// The published time is when the article was published, or when it was first
+// seen if it has no date.
+func (db *DB) LogArticle(feedID string, articleID string, published int64) {
 	_, err := db.Exec(
-		"INSERT OR IGNORE INTO articles (feed_id, article_id) VALUES (?, ?)",
-		feedID, articleID,
+		"INSERT OR IGNORE INTO articles (feed_id, article_id, published) VALUES (?, ?, ?)",
+		feedID, articleID, published,
+	)
+	if err != nil {
+		log.Fatalf("failed to write to database: %v", err)
+	}
+}
+
+// GetArticleTimes returns the published times of the most recent articles in a
+// feed, newest first.
+func (db *DB) GetArticleTimes(feedID string, limit int) []int64 {
+	rows, err := db.Query(
+		"SELECT published FROM articles WHERE feed_id = ? AND published > 0 ORDER BY published DESC LIMIT ?",
+		feedID, limit,
+	)
+	if err != nil {
+		log.Fatalf("failed to read from database: %v", err)
+	}
+	defer rows.Close()
+
+	var times []int64
+	for rows.Next() {
+		var published int64
+		if err := rows.Scan(&published); err != nil {
+			log.Fatalf("failed to read from database: %v", err)
+		}
+		times = append(times, published)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatalf("failed to read from database: %v", err)
+	}
+
+	return times
+}
+
+// ClaimArticle records that an article in a dedup scope is being sent to a
+// notifier. It returns false if the article was already claimed, possibly by
+// another feed in the same scope.
+func (db *DB) ClaimArticle(scope string, notifierID string, articleID string) bool {
+	result, err := db.Exec(
+		"INSERT OR IGNORE INTO dedup (scope, notifier, article_id) VALUES (?, ?, ?)",
+		scope, notifierID, articleID,
+	)
+	if err != nil {
+		log.Fatalf("failed to write to database: %v", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		log.Fatalf("failed to write to database: %v", err)
+	}
+
+	return rows > 0
+}
+
+// ReleaseArticle removes a claim on an article in a dedup scope, eg if sending
+// the notification failed.
+func (db *DB) ReleaseArticle(scope string, notifierID string, articleID string) {
+	_, err := db.Exec(
+		"DELETE FROM dedup WHERE scope = ? AND notifier = ? AND article_id = ?",
+		scope, notifierID, articleID,
 	)
 	if err != nil {
 		log.Fatalf("failed to write to database: %v", err)
 	}
 }
+
+// LogFetch records an attempt to fetch a feed.
+func (db *DB) LogFetch(fetch *Fetch) {
+	_, err := db.Exec(`
+        INSERT INTO fetches (feed_id, timestamp, duration_ms, http_status, bytes, items, new_items, error)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+    `, fetch.FeedID, fetch.Timestamp, fetch.DurationMs, fetch.HTTPStatus, fetch.Bytes,
+		fetch.Items, fetch.NewItems, fetch.Error)
+	if err != nil {
+		log.Fatalf("failed to write to database: %v", err)
+	}
+}
+
+// PruneFetches deletes fetch records older than the given time, and returns
+// the number of records deleted.
+func (db *DB) PruneFetches(before int64) int64 {
+	result, err := db.Exec("DELETE FROM fetches WHERE timestamp < ?", before)
+	if err != nil {
+		log.Fatalf("failed to write to database: %v", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		log.Fatalf("failed to write to database: %v", err)
+	}
+
+	return rows
+}
+
+// GetSubscription retrieves the WebSub subscription of a feed.
+func (db *DB) GetSubscription(feedID string) *Subscription {
+	var sub Subscription
+	err := db.QueryRow(`
+        SELECT feed_id, hub, topic, secret, requested, expires
+        FROM websub WHERE feed_id = ?
+    `, feedID).Scan(&sub.FeedID, &sub.Hub, &sub.Topic, &sub.Secret, &sub.Requested, &sub.Expires)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		log.Fatalf("failed to read from database: %v", err)
+	}
+	return &sub
+}
+
+// GetSubscriptions retrieves all WebSub subscriptions.
+func (db *DB) GetSubscriptions() []*Subscription {
+	rows, err := db.Query("SELECT feed_id, hub, topic, secret, requested, expires FROM websub")
+	if err != nil {
+		log.Fatalf("failed to read from database: %v", err)
+	}
+	defer rows.Close()
+
+	var subs []*Subscription
+	for rows.Next() {
+		var sub Subscription
+		if err := rows.Scan(&sub.FeedID, &sub.Hub, &sub.Topic, &sub.Secret, &sub.Requested, &sub.Expires); err != nil {
+			log.Fatalf("failed to read from database: %v", err)
+		}
+		subs = append(subs, &sub)
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatalf("failed to read from database: %v", err)
+	}
+
+	return subs
+}
+
+// UpdateSubscription creates or updates the WebSub subscription of a feed.
+func (db *DB) UpdateSubscription(sub *Subscription) {
+	_, err := db.Exec(`
+        INSERT INTO websub (feed_id, hub, topic, secret, requested, expires)
+        VALUES (?, ?, ?, ?, ?, ?)
+        ON CONFLICT(feed_id) DO UPDATE SET
+            hub = excluded.hub,
+            topic = excluded.topic,
+            secret = excluded.secret,
+            requested = excluded.requested,
+            expires = excluded.expires
+    `, sub.FeedID, sub.Hub, sub.Topic, sub.Secret, sub.Requested, sub.Expires)
+	if err != nil {
+		log.Fatalf("failed to write to database: %v", err)
+	}
+}
+
+// DeleteSubscription deletes the WebSub subscription of a feed.
+func (db *DB) DeleteSubscription(feedID string) {
+	_, err := db.Exec("DELETE FROM websub WHERE feed_id = ?", feedID)
+	if err != nil {
+		log.Fatalf("failed to write to database: %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+package discover
+
+import (
+	"bytes"
+	"mime"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// feedTypes are the MIME types of feed links, in order of preference. Links
+// of type application/json aren't included, because they're usually APIs
+// rather than JSON Feeds, eg the wp-json links of WordPress pages.
+var feedTypes = []string{
+	"application/atom+xml",
+	"application/rss+xml",
+	"application/feed+json",
+}
+
+// Candidate is a feed linked from an HTML page.
+type Candidate struct {
+	URL   string
+	Type  string
+	Title string
+}
+
+// Find returns the feeds advertised by `<link rel="alternate">` tags in an
+// HTML page, in order of preference. Relative links are resolved against
+// pageURL, or the page's `<base>` if it has one.
+func Find(body []byte, pageURL string) ([]Candidate, error) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return nil, err
+	}
+	if href, ok := doc.Find("base[href]").First().Attr("href"); ok {
+		if baseHref, err := base.Parse(strings.TrimSpace(href)); err == nil {
+			base = baseHref
+		}
+	}
+
+	var candidates []Candidate
+	seen := make(map[string]bool)
+
+	doc.Find("link[rel][href][type]").Each(func(_ int, link *goquery.Selection) {
+		rel := strings.Fields(strings.ToLower(link.AttrOr("rel", "")))
+		if !slices.Contains(rel, "alternate") {
+			return
+		}
+
+		mediaType, _, err := mime.ParseMediaType(link.AttrOr("type", ""))
+		if err != nil || !slices.Contains(feedTypes, mediaType) {
+			return
+		}
+
+		href, err := base.Parse(strings.TrimSpace(link.AttrOr("href", "")))
+		if err != nil || (href.Scheme != "http" && href.Scheme != "https") {
+			return
+		}
+		href.Fragment = ""
+
+		if seen[href.String()] {
+			return
+		}
+		seen[href.String()] = true
+
+		candidates = append(candidates, Candidate{
+			URL:   href.String(),
+			Type:  mediaType,
+			Title: strings.TrimSpace(link.AttrOr("title", "")),
+		})
+	})
+
+	// Sort by type, keeping the order of the page for each type.
+	slices.SortStableFunc(candidates, func(a, b Candidate) int {
+		return slices.Index(feedTypes, a.Type) - slices.Index(feedTypes, b.Type)
+	})
+
+	return candidates, nil
+}
+
+// IsHTML reports whether a response looks like an HTML page, from its
+// Content-Type header or the start of its body.
+func IsHTML(contentType string, body []byte) bool {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		if mediaType == "text/html" || mediaType == "application/xhtml+xml" {
+			return true
+		}
+	}
+
+	start := bytes.TrimPrefix(body[:min(len(body), 512)], []byte("\xef\xbb\xbf"))
+	start = bytes.ToLower(bytes.TrimSpace(start))
+	return bytes.HasPrefix(start, []byte("<!doctype html")) || bytes.HasPrefix(start, []byte("<html"))
+}
@@ -0,0 +1,145 @@
+package jsonapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jamielinux/feed-notifier/internal/config"
+	"github.com/jamielinux/feed-notifier/internal/dateparse"
+	"github.com/jamielinux/feed-notifier/internal/jsonpath"
+	"github.com/mmcdole/gofeed"
+)
+
+// Parse extracts articles from a JSON response using the paths in settings.
+// Relative links are resolved against endpointURL. Dates can be strings, in
+// which case those without a time zone are in loc, or Unix timestamps in
+// seconds or milliseconds.
+func Parse(body []byte, endpointURL string, settings *config.JSONSettings, loc *time.Location) (*gofeed.Feed, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var document any
+	if err := decoder.Decode(&document); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	base, err := url.Parse(endpointURL)
+	if err != nil {
+		return nil, err
+	}
+
+	itemsPath, err := jsonpath.Parse(settings.Items)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := itemsPath.Get(document)
+	if !ok {
+		return nil, fmt.Errorf("json.items path '%s' was not found in the response", settings.Items)
+	}
+	objects, ok := value.([]any)
+	if !ok {
+		return nil, fmt.Errorf("json.items path '%s' is not an array", settings.Items)
+	}
+
+	feed := &gofeed.Feed{
+		Link:     endpointURL,
+		FeedType: config.TypeJSON,
+	}
+
+	for _, object := range objects {
+		item := &gofeed.Item{
+			GUID:    getString(object, settings.ID),
+			Title:   getString(object, settings.Title),
+			Content: getString(object, settings.Content),
+		}
+
+		if link := getString(object, settings.Link); link != "" {
+			if linkURL, err := base.Parse(link); err == nil {
+				item.Link = linkURL.String()
+				item.Links = []string{item.Link}
+			}
+		}
+
+		if date, ok := get(object, settings.Date); ok {
+			item.Published = toString(date)
+			item.PublishedParsed = parseDate(date, settings.DateFormat, loc)
+		}
+
+		if item.GUID == "" && item.Title == "" && item.Link == "" {
+			continue
+		}
+		feed.Items = append(feed.Items, item)
+	}
+
+	return feed, nil
+}
+
+// get returns the value at a path in an object. It returns false if the path
+// is empty, ie the setting isn't configured, or if there's no value there.
+func get(object any, expr string) (any, bool) {
+	if expr == "" {
+		return nil, false
+	}
+	path, err := jsonpath.Parse(expr)
+	if err != nil {
+		return nil, false
+	}
+	return path.Get(object)
+}
+
+// getString returns the value at a path in an object as a string.
+func getString(object any, expr string) string {
+	value, ok := get(object, expr)
+	if !ok {
+		return ""
+	}
+	return strings.TrimSpace(toString(value))
+}
+
+// toString converts a JSON value to a string. Arrays and objects are encoded
+// as JSON.
+func toString(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		if v {
+			return "true"
+		}
+		return "false"
+	default:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return ""
+		}
+		return string(encoded)
+	}
+}
+
+// parseDate parses a date that's either a string or a Unix timestamp. It
+// returns nil if the date can't be parsed.
+func parseDate(value any, layout string, loc *time.Location) *time.Time {
+	number, ok := value.(json.Number)
+	if !ok {
+		return dateparse.Parse(strings.TrimSpace(toString(value)), layout, loc)
+	}
+
+	timestamp, err := number.Float64()
+	if err != nil {
+		return nil
+	}
+	// Timestamps after the year 33658 in seconds are assumed to be in
+	// milliseconds.
+	if timestamp > 1e12 {
+		timestamp /= 1000
+	}
+	t := time.Unix(0, int64(timestamp*float64(time.Second))).In(loc)
+	return &t
+}
@@ -0,0 +1,95 @@
+package jsonpath
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Path is a parsed path expression, such as "$.incidents[0].name".
+type Path []segment
+
+// segment is either an object key or an array index.
+type segment struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+// Parse parses a path expression. This is a subset of JSONPath: an optional
+// leading "$", then object keys separated by dots, array indexes such as
+// "[0]" (negative indexes count from the end) and quoted keys such as
+// "['key.with.dots']". An empty expression or "$" is the whole value.
+func Parse(expr string) (Path, error) {
+	rest := strings.TrimSpace(expr)
+	rest = strings.TrimPrefix(rest, "$")
+
+	var path Path
+	for rest != "" {
+		switch {
+		case rest[0] == '.':
+			rest = rest[1:]
+			if rest == "" || rest[0] == '.' || rest[0] == '[' {
+				return nil, fmt.Errorf("invalid path '%s': expected a key after '.'", expr)
+			}
+			fallthrough
+		case rest[0] != '[':
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			path = append(path, segment{key: rest[:end]})
+			rest = rest[end:]
+		default:
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid path '%s': missing ']'", expr)
+			}
+			inner := strings.TrimSpace(rest[1:end])
+			rest = rest[end+1:]
+
+			if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+				path = append(path, segment{key: inner[1 : len(inner)-1]})
+				continue
+			}
+			index, err := strconv.Atoi(inner)
+			if err != nil {
+				return nil, fmt.Errorf("invalid path '%s': '[%s]' is not an array index or quoted key", expr, inner)
+			}
+			path = append(path, segment{index: index, isIndex: true})
+		}
+	}
+
+	return path, nil
+}
+
+// Get returns the value at the path in a decoded JSON value, and whether it
+// exists.
+func (p Path) Get(value any) (any, bool) {
+	for _, seg := range p {
+		if seg.isIndex {
+			array, ok := value.([]any)
+			if !ok {
+				return nil, false
+			}
+			index := seg.index
+			if index < 0 {
+				index += len(array)
+			}
+			if index < 0 || index >= len(array) {
+				return nil, false
+			}
+			value = array[index]
+		} else {
+			object, ok := value.(map[string]any)
+			if !ok {
+				return nil, false
+			}
+			if value, ok = object[seg.key]; !ok {
+				return nil, false
+			}
+		}
+	}
+
+	return value, true
+}
@@ -0,0 +1,152 @@
+package netpolicy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/jamielinux/feed-notifier/internal/config"
+)
+
+// blockedPrefixes are blocked in addition to loopback, link-local, private,
+// multicast and unspecified addresses.
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),      // "this" network
+	netip.MustParsePrefix("100.64.0.0/10"),  // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),   // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"),  // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),    // reserved
+	netip.MustParsePrefix("64:ff9b:1::/48"), // local-use NAT64
+	netip.MustParsePrefix("2001::/32"),      // Teredo
+}
+
+// nat64Prefix and sixToFourPrefix are IPv6 prefixes of addresses that embed an
+// IPv4 address, which is reached through a translator or relay.
+var (
+	nat64Prefix     = netip.MustParsePrefix("64:ff9b::/96")
+	sixToFourPrefix = netip.MustParsePrefix("2002::/16")
+)
+
+// Policy decides which addresses may be connected to when fetching feeds and
+// sending notifications.
+type Policy struct {
+	blockPrivate    bool
+	allowedPrefixes []netip.Prefix
+	allowedHosts    map[string]bool
+}
+
+// New creates a Policy from the network_policy settings.
+func New(settings *config.NetworkPolicy) *Policy {
+	p := &Policy{
+		blockPrivate: settings.BlockPrivate,
+		allowedHosts: make(map[string]bool),
+	}
+
+	for _, entry := range settings.Allow {
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			p.allowedPrefixes = append(p.allowedPrefixes, prefix.Masked())
+		} else if addr, err := netip.ParseAddr(entry); err == nil {
+			p.allowedPrefixes = append(p.allowedPrefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+		} else {
+			p.allowedHosts[strings.ToLower(entry)] = true
+		}
+	}
+
+	return p
+}
+
+// BlocksPrivate returns true if connections to private addresses are blocked.
+func (p *Policy) BlocksPrivate() bool {
+	return p.blockPrivate
+}
+
+// DialContext returns a dial function for a http.Transport that enforces the
+// policy. The check happens after DNS resolution, on the address actually
+// being connected to, so DNS rebinding can't be used to bypass it.
+func (p *Policy) DialContext() func(ctx context.Context, network, address string) (net.Conn, error) {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if !p.blockPrivate {
+		return dialer.DialContext
+	}
+
+	checkedDialer := &net.Dialer{
+		Timeout:   dialer.Timeout,
+		KeepAlive: dialer.KeepAlive,
+		Control:   p.control,
+	}
+
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(address)
+		if err == nil && p.allowedHosts[strings.ToLower(host)] {
+			return dialer.DialContext(ctx, network, address)
+		}
+		return checkedDialer.DialContext(ctx, network, address)
+	}
+}
+
+// control is called with the resolved address of each connection.
+func (p *Policy) control(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("network policy: invalid address '%s': %w", address, err)
+	}
+
+	if !p.Allowed(addrPort.Addr()) {
+		return fmt.Errorf("network policy: connection to %s is blocked", addrPort.Addr())
+	}
+
+	return nil
+}
+
+// Allowed reports whether an address may be connected to.
+func (p *Policy) Allowed(addr netip.Addr) bool {
+	if !p.blockPrivate {
+		return true
+	}
+
+	addr = addr.Unmap()
+	for _, prefix := range p.allowedPrefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	// The IPv4 address embedded in an IPv6 address is what's connected to in
+	// the end, so it's allowed or blocked like the IPv4 address itself.
+	if embedded, ok := embeddedIPv4(addr); ok {
+		return p.Allowed(embedded)
+	}
+
+	if addr.IsLoopback() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsPrivate() || addr.IsMulticast() || addr.IsUnspecified() || addr.IsInterfaceLocalMulticast() {
+		return false
+	}
+
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// embeddedIPv4 returns the IPv4 address embedded in a NAT64 or 6to4 address.
+func embeddedIPv4(addr netip.Addr) (netip.Addr, bool) {
+	bytes := addr.As16()
+	switch {
+	case nat64Prefix.Contains(addr):
+		return netip.AddrFrom4([4]byte(bytes[12:16])), true
+	case sixToFourPrefix.Contains(addr):
+		return netip.AddrFrom4([4]byte(bytes[2:6])), true
+	default:
+		return netip.Addr{}, false
+	}
+}
@@ -0,0 +1,126 @@
+package netpolicy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/jamielinux/feed-notifier/internal/config"
+)
+
+func TestAllowed(t *testing.T) {
+	blockPrivate := New(&config.NetworkPolicy{BlockPrivate: true})
+	withAllow := New(&config.NetworkPolicy{
+		BlockPrivate: true,
+		Allow:        []string{"10.20.0.0/16", "192.168.1.5", "intranet.example.com"},
+	})
+
+	tests := []struct {
+		addr    string
+		policy  *Policy
+		allowed bool
+	}{
+		// Public addresses.
+		{"93.184.216.34", blockPrivate, true},
+		{"2606:2800:220:1:248:1893:25c8:1946", blockPrivate, true},
+		{"::ffff:93.184.216.34", blockPrivate, true},
+
+		// Special addresses.
+		{"127.0.0.1", blockPrivate, false},
+		{"::1", blockPrivate, false},
+		{"10.1.2.3", blockPrivate, false},
+		{"172.16.0.1", blockPrivate, false},
+		{"192.168.1.1", blockPrivate, false},
+		{"169.254.169.254", blockPrivate, false},
+		{"fe80::1", blockPrivate, false},
+		{"fd00::1", blockPrivate, false},
+		{"0.0.0.0", blockPrivate, false},
+		{"::", blockPrivate, false},
+		{"100.64.0.1", blockPrivate, false},
+		{"198.18.0.1", blockPrivate, false},
+		{"224.0.0.1", blockPrivate, false},
+		{"ff02::1", blockPrivate, false},
+		{"::ffff:127.0.0.1", blockPrivate, false},
+		{"::ffff:169.254.169.254", blockPrivate, false},
+
+		// IPv6 addresses that embed an IPv4 address.
+		{"64:ff9b::a9fe:a9fe", blockPrivate, false},
+		{"64:ff9b::7f00:1", blockPrivate, false},
+		{"64:ff9b::5db8:d822", blockPrivate, true},
+		{"2002:a9fe:a9fe::1", blockPrivate, false},
+		{"2002:0a00:0001::1", blockPrivate, false},
+		{"2002:5db8:d822::1", blockPrivate, true},
+		{"64:ff9b:1::a9fe:a9fe", blockPrivate, false},
+		{"2001:0:4136:e378:8000:63bf:3fff:fdd2", blockPrivate, false},
+
+		// The allowlist.
+		{"10.20.1.2", withAllow, true},
+		{"10.21.1.2", withAllow, false},
+		{"192.168.1.5", withAllow, true},
+		{"192.168.1.6", withAllow, false},
+		{"::ffff:10.20.1.2", withAllow, true},
+		{"64:ff9b::a14:102", withAllow, true},
+		{"127.0.0.1", withAllow, false},
+
+		// Nothing is blocked unless block_private is enabled.
+		{"127.0.0.1", New(&config.NetworkPolicy{}), true},
+		{"169.254.169.254", New(&config.NetworkPolicy{}), true},
+	}
+
+	for _, tt := range tests {
+		if got := tt.policy.Allowed(netip.MustParseAddr(tt.addr)); got != tt.allowed {
+			t.Errorf("Allowed(%s) = %t, want %t", tt.addr, got, tt.allowed)
+		}
+	}
+}
+
+func TestDialContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(policy *Policy, rawURL string) error {
+		transport := &http.Transport{DialContext: policy.DialContext()}
+		defer transport.CloseIdleConnections()
+		resp, err := (&http.Client{Transport: transport}).Get(rawURL)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+
+	err = get(New(&config.NetworkPolicy{BlockPrivate: true}), server.URL)
+	if err == nil || !strings.Contains(err.Error(), "network policy: connection to 127.0.0.1 is blocked") {
+		t.Errorf("connection to loopback wasn't blocked: %v", err)
+	}
+
+	// localhost resolves to a loopback address, which is checked after the
+	// lookup.
+	localhostURL := "http://localhost:" + serverURL.Port()
+	if err := get(New(&config.NetworkPolicy{BlockPrivate: true}), localhostURL); err == nil {
+		t.Errorf("connection to localhost wasn't blocked")
+	}
+
+	allowed := []*Policy{
+		New(&config.NetworkPolicy{}),
+		New(&config.NetworkPolicy{BlockPrivate: true, Allow: []string{"127.0.0.0/8"}}),
+		New(&config.NetworkPolicy{BlockPrivate: true, Allow: []string{"127.0.0.1"}}),
+	}
+	for _, policy := range allowed {
+		if err := get(policy, server.URL); err != nil {
+			t.Errorf("connection to allowed address failed: %v", err)
+		}
+	}
+
+	allowHost := New(&config.NetworkPolicy{BlockPrivate: true, Allow: []string{"LOCALHOST"}})
+	if err := get(allowHost, localhostURL); err != nil {
+		t.Errorf("connection to allowed host failed: %v", err)
+	}
+}
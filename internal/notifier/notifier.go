@@ -2,6 +2,7 @@ package notifier
 
 import (
 	"fmt"
+	"net/http"
 
 	"github.com/jamielinux/feed-notifier/internal/config"
 	"github.com/mmcdole/gofeed"
@@ -13,11 +14,16 @@ type Notifier interface {
 }
 
 // NotifierFactory handles the creation of Notifier instances.
-type NotifierFactory struct{}
+type NotifierFactory struct {
+	client *http.Client
+}
 
-// NewFactory creates a new NotifierFactory.
-func NewFactory() *NotifierFactory {
-	return &NotifierFactory{}
+// NewFactory creates a new NotifierFactory. Notifiers that make HTTP requests
+// use the given client.
+func NewFactory(client *http.Client) *NotifierFactory {
+	return &NotifierFactory{
+		client: client,
+	}
 }
 
 // Create creates notifier instances.
@@ -25,10 +31,10 @@ func (f *NotifierFactory) Create(notifierConfig *config.Notifier) (Notifier, err
 	switch notifierConfig.Type {
 	case config.NotifierMattermostWebhook:
 		settings := notifierConfig.Settings.(*config.MattermostWebhookSettings)
-		return NewMattermostWebhook(settings), nil
+		return NewMattermostWebhook(settings, f.client), nil
 	case config.NotifierPushover:
 		settings := notifierConfig.Settings.(*config.PushoverSettings)
-		return NewPushover(settings), nil
+		return NewPushover(settings, f.client), nil
 	default:
 		return nil, fmt.Errorf("unsupported notifier type: %s", notifierConfig.Type)
 	}
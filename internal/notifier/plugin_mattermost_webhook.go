@@ -16,6 +16,7 @@ import (
 // MattermostWebhookNotifier sends notifications via Mattermost webhook.
 type MattermostWebhookNotifier struct {
 	settings *config.MattermostWebhookSettings
+	client   *http.Client
 }
 
 // MattermostAttachment represents a Mattermost message attachment.
@@ -37,9 +38,10 @@ type MattermostMessage struct {
 }
 
 // NewMattermostWebhook creates a new Mattermost webhook notifier.
-func NewMattermostWebhook(settings *config.MattermostWebhookSettings) *MattermostWebhookNotifier {
+func NewMattermostWebhook(settings *config.MattermostWebhookSettings, client *http.Client) *MattermostWebhookNotifier {
 	return &MattermostWebhookNotifier{
 		settings: settings,
+		client:   client,
 	}
 }
 
@@ -88,7 +90,7 @@ func (notifier *MattermostWebhookNotifier) Notify(feed *config.Feed, item *gofee
 		return fmt.Errorf("failed to prepare Mattermost notification: %w", err)
 	}
 
-	resp, err := http.Post(notifier.settings.Webhook, "application/json", bytes.NewBuffer(payload))
+	resp, err := notifier.client.Post(notifier.settings.Webhook, "application/json", bytes.NewBuffer(payload))
 	if err != nil {
 		return fmt.Errorf("failed to send Mattermost webhook notification: %w", err)
 	}
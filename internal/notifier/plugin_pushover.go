@@ -14,12 +14,14 @@ import (
 // PushoverNotifier sends notifications via Pushover.
 type PushoverNotifier struct {
 	settings *config.PushoverSettings
+	client   *http.Client
 }
 
 // NewPushover creates a new Pushover notifier.
-func NewPushover(settings *config.PushoverSettings) *PushoverNotifier {
+func NewPushover(settings *config.PushoverSettings, client *http.Client) *PushoverNotifier {
 	return &PushoverNotifier{
 		settings: settings,
+		client:   client,
 	}
 }
 
@@ -34,7 +36,7 @@ func (n *PushoverNotifier) Notify(feed *config.Feed, item *gofeed.Item) error {
 		message = "(no title)"
 	}
 
-	resp, err := http.PostForm("https://api.pushover.net/1/messages.json", url.Values{
+	resp, err := n.client.PostForm("https://api.pushover.net/1/messages.json", url.Values{
 		"token":     {n.settings.AppToken},
 		"user":      {n.settings.UserKey},
 		"title":     {title},
@@ -14,9 +14,10 @@ import (
 // ArticleNotification represents the data to be output as JSON.
 type ArticleNotification struct {
 	Feed struct {
-		ID          string `json:"id"`
-		DisplayName string `json:"display_name"`
-		URL         string `json:"url"`
+		ID          string   `json:"id"`
+		DisplayName string   `json:"display_name"`
+		URL         string   `json:"url"`
+		Tags        []string `json:"tags,omitempty"`
 	} `json:"feed"`
 	Article struct {
 		GUID        string    `json:"guid,omitempty"`
@@ -50,6 +51,7 @@ func (n *StdoutNotifier) Notify(feed *config.Feed, item *gofeed.Item) error {
 	notification.Feed.ID = feed.ID
 	notification.Feed.DisplayName = feed.DisplayName
 	notification.Feed.URL = feed.URL
+	notification.Feed.Tags = feed.Tags
 
 	notification.Article.Title = item.Title
 	notification.Article.Link = item.Link
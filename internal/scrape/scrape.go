@@ -0,0 +1,105 @@
+package scrape
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/jamielinux/feed-notifier/internal/config"
+	"github.com/jamielinux/feed-notifier/internal/dateparse"
+	"github.com/mmcdole/gofeed"
+)
+
+// Parse extracts articles from an HTML page using the CSS selectors in
+// settings. Relative links are resolved against pageURL, or the page's
+// `<base>` if it has one. Dates without a time zone are in loc.
+func Parse(body []byte, pageURL string, settings *config.ScrapeSettings, loc *time.Location) (*gofeed.Feed, error) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return nil, err
+	}
+	if href, ok := doc.Find("base[href]").First().Attr("href"); ok {
+		if baseHref, err := base.Parse(strings.TrimSpace(href)); err == nil {
+			base = baseHref
+		}
+	}
+
+	feed := &gofeed.Feed{
+		Title:    collapseSpace(doc.Find("title").First().Text()),
+		Link:     pageURL,
+		FeedType: config.TypeScrape,
+	}
+
+	items := doc.Find(settings.Items)
+	if items.Length() == 0 {
+		// The page has probably changed, so treat it as a failure rather
+		// than silently finding nothing.
+		return nil, fmt.Errorf("no elements match the scrape.items selector '%s'", settings.Items)
+	}
+
+	items.Each(func(_ int, selection *goquery.Selection) {
+		item := &gofeed.Item{
+			Title: collapseSpace(selection.Find(settings.Title).First().Text()),
+		}
+
+		if link := findLink(selection, settings.Link); link != "" {
+			if linkURL, err := base.Parse(link); err == nil {
+				item.Link = linkURL.String()
+				item.Links = []string{item.Link}
+			}
+		}
+
+		if settings.Date != "" {
+			element := selection.Find(settings.Date).First()
+			item.Published = collapseSpace(element.AttrOr("datetime", element.Text()))
+			item.PublishedParsed = dateparse.Parse(item.Published, settings.DateFormat, loc)
+		}
+
+		if settings.Content != "" {
+			element := selection.Find(settings.Content).First()
+			if content, err := element.Html(); err == nil {
+				item.Content = strings.TrimSpace(content)
+			}
+			item.Description = collapseSpace(element.Text())
+		}
+
+		if item.Title == "" && item.Link == "" {
+			return
+		}
+		feed.Items = append(feed.Items, item)
+	})
+
+	return feed, nil
+}
+
+// findLink returns the href of the element matching selector, or of the first
+// link inside it. With no selector, the item itself or the first link inside
+// it is used.
+func findLink(item *goquery.Selection, selector string) string {
+	element := item
+	if selector != "" {
+		element = item.Find(selector).First()
+	}
+
+	if href, ok := element.Attr("href"); ok {
+		return strings.TrimSpace(href)
+	}
+	if href, ok := element.Find("a[href]").First().Attr("href"); ok {
+		return strings.TrimSpace(href)
+	}
+	return ""
+}
+
+// collapseSpace trims a string and replaces runs of whitespace with a single
+// space.
+func collapseSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
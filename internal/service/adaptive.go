@@ -0,0 +1,140 @@
+package service
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jamielinux/feed-notifier/internal/config"
+	"github.com/jamielinux/feed-notifier/internal/cron"
+	"github.com/jamielinux/feed-notifier/internal/db"
+	"github.com/jamielinux/feed-notifier/internal/logger"
+	"github.com/mmcdole/gofeed"
+)
+
+// autoSampleSize is the number of recent articles used to estimate how often
+// a feed is updated.
+const autoSampleSize = 20
+
+// updatePeriods are the values of sy:updatePeriod, in seconds.
+var updatePeriods = map[string]int64{
+	"hourly":  3600,
+	"daily":   86400,
+	"weekly":  7 * 86400,
+	"monthly": 30 * 86400,
+	"yearly":  365 * 86400,
+}
+
+// updateFeedHints stores the hints that a feed gives about how often it should
+// be fetched: the RSS ttl, sy:updatePeriod and sy:updateFrequency, skipHours
+// and skipDays.
+func updateFeedHints(metadata *db.Feed, parsedFeed *gofeed.Feed) {
+	metadata.TTL = 0
+	if minutes, err := strconv.ParseInt(strings.TrimSpace(parsedFeed.Custom["ttl"]), 10, 64); err == nil && minutes > 0 {
+		metadata.TTL = minutes * 60
+	}
+	if period := syndicationPeriod(parsedFeed); period > metadata.TTL {
+		metadata.TTL = period
+	}
+
+	var hours []string
+	for _, value := range strings.Split(parsedFeed.Custom["skipHours"], ",") {
+		hour, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || hour < 0 || hour > 24 {
+			continue
+		}
+		// Some feeds use 24 for midnight.
+		hours = append(hours, strconv.Itoa(hour%24))
+	}
+	metadata.SkipHours = strings.Join(hours, ",")
+
+	var days []string
+	for _, value := range strings.Split(parsedFeed.Custom["skipDays"], ",") {
+		value = strings.TrimSpace(value)
+		if len(value) < 3 {
+			continue
+		}
+		if day, ok := cron.ParseWeekday(value[:3]); ok {
+			days = append(days, day.String())
+		}
+	}
+	metadata.SkipDays = strings.Join(days, ",")
+}
+
+// syndicationPeriod returns the time in seconds between updates given by the
+// sy:updatePeriod and sy:updateFrequency elements of a feed, or 0 if there
+// are none.
+func syndicationPeriod(parsedFeed *gofeed.Feed) int64 {
+	sy, ok := parsedFeed.Extensions["sy"]
+	if !ok {
+		return 0
+	}
+
+	var period, frequency int64 = 0, 1
+	if values := sy["updatePeriod"]; len(values) > 0 {
+		period = updatePeriods[strings.ToLower(strings.TrimSpace(values[0].Value))]
+	}
+	if values := sy["updateFrequency"]; len(values) > 0 {
+		if n, err := strconv.ParseInt(strings.TrimSpace(values[0].Value), 10, 64); err == nil && n > 0 {
+			frequency = n
+		}
+	}
+	if period == 0 {
+		// The default period is daily, but only if a frequency is given.
+		if _, ok := sy["updateFrequency"]; !ok {
+			return 0
+		}
+		period = updatePeriods["daily"]
+	}
+
+	return period / frequency
+}
+
+// autoInterval returns the interval for a feed with an automatic interval.
+// It's half of the average time between its recent articles, or half of the
+// time since its last article if that's longer, so feeds that have gone quiet
+// are fetched less often. The interval is at least the feed's ttl, and is
+// kept between min_interval and max_interval.
+func (s *Service) autoInterval(feed *config.Feed, metadata *db.Feed, now time.Time) time.Duration {
+	interval := feed.MinInterval.Duration()
+
+	times := s.db.GetArticleTimes(feed.ID, autoSampleSize)
+	if len(times) > 0 {
+		gap := now.Sub(time.Unix(times[0], 0))
+		if len(times) > 1 {
+			average := time.Duration(times[0]-times[len(times)-1]) * time.Second / time.Duration(len(times)-1)
+			gap = max(gap, average)
+		}
+		interval = gap / 2
+	}
+
+	interval = max(interval, time.Duration(metadata.TTL)*time.Second)
+	interval = min(max(interval, feed.MinInterval.Duration()), feed.MaxInterval.Duration())
+
+	logger.Debug("Automatic interval for feed '%s' is %s", feed.ID, interval.Round(time.Second))
+	return interval
+}
+
+// nextUnskipped returns the earliest time at or after t that isn't in the
+// feed's skipHours or skipDays, which are in UTC. If every hour is skipped
+// then t is returned.
+func nextUnskipped(t time.Time, metadata *db.Feed) time.Time {
+	if metadata.SkipHours == "" && metadata.SkipDays == "" {
+		return t
+	}
+
+	hours := strings.Split(metadata.SkipHours, ",")
+	days := strings.Split(metadata.SkipDays, ",")
+
+	candidate := t.UTC()
+	for range 8 * 24 {
+		if !slices.Contains(hours, strconv.Itoa(candidate.Hour())) &&
+			!slices.Contains(days, candidate.Weekday().String()) {
+			return candidate.In(t.Location())
+		}
+		candidate = candidate.Truncate(time.Hour).Add(time.Hour)
+	}
+
+	return t
+}
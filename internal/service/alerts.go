@@ -0,0 +1,105 @@
+package service
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jamielinux/feed-notifier/internal/config"
+	"github.com/jamielinux/feed-notifier/internal/db"
+	"github.com/mmcdole/gofeed"
+)
+
+// sendAlert sends an alert about a feed to the admin notifier, if one is
+// configured.
+func (s *Service) sendAlert(feed *config.Feed, title string, message string) {
+	if s.config.AdminNotifier == "" {
+		return
+	}
+
+	// Notifiers log the feed's notifier, so send the alert as if it came from
+	// a copy of the feed that uses the admin notifier.
+	alertFeed := *feed
+	alertFeed.Notifier = s.config.AdminNotifier
+
+	now := time.Now()
+	item := &gofeed.Item{
+		Title:           title,
+		Content:         message,
+		Description:     message,
+		Link:            alertFeed.URL,
+		PublishedParsed: &now,
+	}
+
+	if err := s.notifierMap[s.config.AdminNotifier].Notify(&alertFeed, item); err != nil {
+		log.Printf("Failed to send alert '%s' to admin notifier '%s': %v", title, s.config.AdminNotifier, err)
+	}
+}
+
+// alertFeedFailure sends an alert when a failing feed first reaches either the
+// configured number of consecutive failures or hours of failing.
+func (s *Service) alertFeedFailure(feed *config.Feed, metadata *db.Feed, err error, now time.Time) {
+	if metadata.Alerted {
+		return
+	}
+
+	alerts := s.config.Alerts
+	failingFor := now.Sub(time.Unix(metadata.FailingSince, 0))
+	if metadata.Failures < alerts.Failures &&
+		(alerts.Hours == 0 || failingFor < time.Duration(alerts.Hours)*time.Hour) {
+		return
+	}
+
+	s.sendAlert(feed,
+		fmt.Sprintf("Feed '%s' is failing", feed.DisplayName),
+		fmt.Sprintf("The feed '%s' (%s) has failed %d consecutive times since %s. The last error was: %v",
+			feed.ID, feed.Source(), metadata.Failures, time.Unix(metadata.FailingSince, 0).Format(time.RFC1123), err))
+	metadata.Alerted = true
+}
+
+// alertFeedRecovery sends an alert when a feed that was alerted about as
+// failing is fetched successfully again.
+func (s *Service) alertFeedRecovery(feed *config.Feed, metadata *db.Feed) {
+	if !metadata.Alerted {
+		return
+	}
+
+	s.sendAlert(feed,
+		fmt.Sprintf("Feed '%s' has recovered", feed.DisplayName),
+		fmt.Sprintf("The feed '%s' (%s) was fetched successfully after %d consecutive failures.",
+			feed.ID, feed.Source(), metadata.Failures))
+}
+
+// recordNotifierResult tracks consecutive failures of the notifier for a feed,
+// and sends an alert when it reaches the configured number of failures and
+// when it recovers. Failures of the admin notifier itself are only logged.
+func (s *Service) recordNotifierResult(feed *config.Feed, err error) {
+	notifierID := feed.Notifier
+
+	s.notifierMu.Lock()
+	failures := s.notifierFailures[notifierID]
+	if err != nil {
+		s.notifierFailures[notifierID] = failures + 1
+	} else {
+		delete(s.notifierFailures, notifierID)
+	}
+	s.notifierMu.Unlock()
+
+	if notifierID == s.config.AdminNotifier {
+		return
+	}
+
+	threshold := s.config.Alerts.NotifierFailures
+	switch {
+	case err != nil && failures+1 == threshold:
+		s.sendAlert(feed,
+			fmt.Sprintf("Notifier '%s' is failing", notifierID),
+			fmt.Sprintf("The notifier '%s' has failed %d consecutive times. The last error was: %v",
+				notifierID, failures+1, err))
+	case err == nil && failures >= threshold:
+		s.sendAlert(feed,
+			fmt.Sprintf("Notifier '%s' has recovered", notifierID),
+			fmt.Sprintf("The notifier '%s' sent a notification successfully after %d consecutive failures.",
+				notifierID, failures))
+	}
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jamielinux/feed-notifier/internal/config"
+	"github.com/jamielinux/feed-notifier/internal/db"
+	"github.com/jamielinux/feed-notifier/internal/logger"
+	"github.com/mmcdole/gofeed"
+)
+
+// fetchOlderPages follows the links from a feed to its older pages, as in RFC
+// 5005, for as long as every article on the last page fetched is new. This
+// catches articles that scrolled off a short feed while it wasn't being
+// fetched, eg because the service was down. The articles of older pages are
+// appended to the items of the feed. Errors are only logged, since the feed
+// itself was fetched successfully. hostURL is the URL whose host limiter slot
+// is held while the feed is fetched.
+func (s *Service) fetchOlderPages(feed *config.Feed, metadata *db.Feed, parsedFeed *gofeed.Feed,
+	hostURL string, lastChecked int64) {
+	pageURL := getFetchURL(feed, metadata)
+	page := parsedFeed
+	visited := map[string]bool{pageURL: true}
+
+	for i := 0; i < *feed.MaxPages && s.hasOnlyNewArticles(feed, page, lastChecked); i++ {
+		link := olderPageLink(page)
+		if link == "" {
+			return
+		}
+		nextURL := resolveURL(pageURL, link)
+		if visited[nextURL] {
+			return
+		}
+		visited[nextURL] = true
+
+		logger.Debug("Every article in feed '%s' is new, fetching older page: %s", feed.ID, nextURL)
+		var err error
+		page, err = s.fetchPage(feed, hostURL, nextURL)
+		if err != nil {
+			if s.ctx.Err() == nil {
+				log.Printf("Failed to fetch older page of feed '%s': %v", feed.ID, err)
+			}
+			return
+		}
+		parsedFeed.Items = append(parsedFeed.Items, page.Items...)
+		pageURL = nextURL
+	}
+}
+
+// olderPageLink returns the link to the page before a feed, preferring the
+// previous archive of an archived feed to the next page of a paged feed.
+func olderPageLink(page *gofeed.Feed) string {
+	if link := page.Custom["prev-archive"]; link != "" {
+		return link
+	}
+	return page.Custom["next"]
+}
+
+// hasOnlyNewArticles returns true if a page has articles and none of them
+// have been seen before or are dated before the last successful fetch, in
+// which case there may be more new articles on the page before it.
+func (s *Service) hasOnlyNewArticles(feed *config.Feed, page *gofeed.Feed, lastChecked int64) bool {
+	if len(page.Items) == 0 {
+		return false
+	}
+
+	for _, item := range page.Items {
+		if t := getArticleTime(item); t != nil && t.Unix() < lastChecked {
+			return false
+		}
+		articleID := s.getArticleID(feed, item)
+		if articleID != "" && !s.db.IsArticleNew(feed.ID, articleID) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// fetchPage retrieves and parses a page of a feed, once the host limiter
+// allows it. Unlike fetchFeed, it doesn't use or update the cache metadata of
+// the feed.
+func (s *Service) fetchPage(feed *config.Feed, hostURL, pageURL string) (*gofeed.Feed, error) {
+	timeout := time.Duration(feed.Timeout) * time.Second
+	ctx, cancel := context.WithTimeout(s.ctx, timeout)
+	defer cancel()
+
+	releaseHost, err := s.acquireHost(ctx, hostURL, pageURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wait for host: %w", err)
+	}
+	defer releaseHost()
+
+	req, err := newFeedRequest(ctx, feed, pageURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := s.httpClients[feed.ID].Do(req)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, fmt.Errorf("HTTP request timed out after %s", timeout)
+		}
+		return nil, fmt.Errorf("HTTP request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &statusError{StatusCode: resp.StatusCode}
+	}
+
+	body, err := readBody(resp, feed.MaxBodyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	parsedFeed, err := s.parseFeed(feed, resp.Request.URL.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse feed: %w", err)
+	}
+
+	return parsedFeed, nil
+}
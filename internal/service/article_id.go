@@ -0,0 +1,121 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// trackingParams are query parameters that are always stripped from links by
+// the normalized_link strategy. Parameters starting with "utm_" are also
+// stripped.
+var trackingParams = []string{
+	"fbclid",
+	"gclid",
+	"dclid",
+	"msclkid",
+	"mc_cid",
+	"mc_eid",
+	"igshid",
+	"_hsenc",
+	"_hsmi",
+}
+
+// hashArticle returns a SHA-256 hash of the given article fields, or an empty
+// string if all of the fields are empty.
+func hashArticle(item *gofeed.Item, fields []string) string {
+	h := sha256.New()
+	empty := true
+
+	for _, field := range fields {
+		value := articleField(item, field)
+		if value != "" {
+			empty = false
+		}
+		h.Write([]byte(field))
+		h.Write([]byte{0})
+		h.Write([]byte(value))
+		h.Write([]byte{0})
+	}
+
+	if empty {
+		return ""
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// articleField returns the value of a named article field as a string.
+func articleField(item *gofeed.Item, field string) string {
+	switch field {
+	case "guid":
+		return item.GUID
+	case "link":
+		return item.Link
+	case "title":
+		return item.Title
+	case "description":
+		return item.Description
+	case "content":
+		return item.Content
+	case "published":
+		if item.PublishedParsed != nil {
+			return item.PublishedParsed.UTC().Format(time.RFC3339)
+		}
+		return item.Published
+	case "updated":
+		if item.UpdatedParsed != nil {
+			return item.UpdatedParsed.UTC().Format(time.RFC3339)
+		}
+		return item.Updated
+	default:
+		return ""
+	}
+}
+
+// normalizeLink normalizes a link so that trivially different URLs for the
+// same article compare equal. The scheme and host are lowercased, default
+// ports and fragments are removed, tracking query parameters (plus any
+// parameters in extraParams) are stripped, ignoring case, and the remaining
+// query parameters are sorted. Links that cannot be parsed are returned unchanged.
+func normalizeLink(link string, extraParams []string) string {
+	if link == "" {
+		return ""
+	}
+
+	u, err := url.Parse(strings.TrimSpace(link))
+	if err != nil || u.Host == "" {
+		return link
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	if (u.Scheme == "http" && u.Port() == "80") || (u.Scheme == "https" && u.Port() == "443") {
+		u.Host = u.Hostname()
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	query := u.Query()
+	for param := range query {
+		lower := strings.ToLower(param)
+		if strings.HasPrefix(lower, "utm_") ||
+			slices.Contains(trackingParams, lower) ||
+			slices.ContainsFunc(extraParams, func(extra string) bool {
+				return strings.EqualFold(extra, param)
+			}) {
+			query.Del(param)
+		}
+	}
+	// Encode sorts the parameters by key.
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jamielinux/feed-notifier/internal/config"
+	"github.com/jamielinux/feed-notifier/internal/cron"
+)
+
+// feedSchedule contains the parsed schedule settings of a feed.
+type feedSchedule struct {
+	location *time.Location
+	crons    []*cron.Schedule
+
+	// The active window. If hasHours is false then all hours are active, and
+	// if days is empty then all days are active.
+	hasHours   bool
+	start, end int // minutes since midnight
+	days       map[time.Weekday]bool
+}
+
+// initSchedules parses the schedule settings of each feed.
+func (s *Service) initSchedules() error {
+	for _, feed := range s.config.Feeds {
+		schedule, err := newFeedSchedule(&feed.FeedSettings)
+		if err != nil {
+			return fmt.Errorf("feed '%s': %w", feed.ID, err)
+		}
+		s.schedules[feed.ID] = schedule
+	}
+	return nil
+}
+
+// newFeedSchedule parses the schedule settings of a feed.
+func newFeedSchedule(settings *config.FeedSettings) (*feedSchedule, error) {
+	fs := &feedSchedule{
+		location: time.Local,
+		days:     make(map[time.Weekday]bool),
+	}
+
+	if settings.Timezone != "" {
+		loc, err := time.LoadLocation(settings.Timezone)
+		if err != nil {
+			return nil, err
+		}
+		fs.location = loc
+	}
+
+	for _, expr := range settings.Schedule {
+		c, err := cron.Parse(expr)
+		if err != nil {
+			return nil, err
+		}
+		fs.crons = append(fs.crons, c)
+	}
+
+	if settings.ActiveHours != "" {
+		start, end, err := config.ParseActiveHours(settings.ActiveHours)
+		if err != nil {
+			return nil, err
+		}
+		fs.hasHours = true
+		fs.start, fs.end = start, end
+	}
+
+	for _, day := range settings.ActiveDays {
+		weekday, ok := cron.ParseWeekday(day)
+		if !ok {
+			return nil, fmt.Errorf("invalid day '%s'", day)
+		}
+		fs.days[weekday] = true
+	}
+
+	return fs, nil
+}
+
+// nextCron returns the earliest time after t that matches any of the cron
+// schedules, or the zero time if there are none.
+func (fs *feedSchedule) nextCron(t time.Time) time.Time {
+	var next time.Time
+	for _, c := range fs.crons {
+		candidate := c.Next(t.In(fs.location))
+		if !candidate.IsZero() && (next.IsZero() || candidate.Before(next)) {
+			next = candidate
+		}
+	}
+	return next
+}
+
+// active reports whether t is inside the active window. The days and hours
+// are checked independently, so a window that wraps around midnight starts
+// and ends on active days.
+func (fs *feedSchedule) active(t time.Time) bool {
+	t = t.In(fs.location)
+
+	if len(fs.days) > 0 && !fs.days[t.Weekday()] {
+		return false
+	}
+
+	if fs.hasHours {
+		minute := t.Hour()*60 + t.Minute()
+		if fs.start < fs.end {
+			return minute >= fs.start && minute < fs.end
+		}
+		return minute >= fs.start || minute < fs.end
+	}
+
+	return true
+}
+
+// nextActive returns the earliest time at or after t that's inside the active
+// window.
+func (fs *feedSchedule) nextActive(t time.Time) time.Time {
+	if !fs.hasHours && len(fs.days) == 0 {
+		return t
+	}
+
+	candidate := t
+	for range 8 * 24 * 60 {
+		if fs.active(candidate) {
+			return candidate
+		}
+		candidate = candidate.Truncate(time.Minute).Add(time.Minute)
+	}
+
+	// Unreachable, because there's always an active minute within a week.
+	return t
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// hostLimiter limits the number of concurrent requests to each host, and
+// enforces a minimum delay between the start of requests to the same host.
+type hostLimiter struct {
+	jobs  int
+	delay time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]*hostSlot
+}
+
+// hostSlot holds the state of a single host.
+type hostSlot struct {
+	semaphore chan struct{}
+
+	mu   sync.Mutex
+	next time.Time // earliest start of the next request
+}
+
+// newHostLimiter creates a hostLimiter.
+func newHostLimiter(jobs int, delay time.Duration) *hostLimiter {
+	return &hostLimiter{
+		jobs:  jobs,
+		delay: delay,
+		hosts: make(map[string]*hostSlot),
+	}
+}
+
+// acquire waits until a request to the host of rawURL is allowed. The returned
+// function must be called to release the slot once the request is finished.
+func (l *hostLimiter) acquire(ctx context.Context, rawURL string) (func(), error) {
+	slot := l.getSlot(hostOf(rawURL))
+
+	select {
+	case slot.semaphore <- struct{}{}:
+		// got a slot, continue
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	release := func() { <-slot.semaphore }
+
+	if err := slot.wait(ctx, l.delay); err != nil {
+		release()
+		return nil, err
+	}
+
+	return release, nil
+}
+
+// wait waits until another request to the host of rawURL is allowed, for a
+// caller that already holds a slot for the host.
+func (l *hostLimiter) wait(ctx context.Context, rawURL string) error {
+	return l.getSlot(hostOf(rawURL)).wait(ctx, l.delay)
+}
+
+// wait waits for the delay since the start of the last request to the host.
+func (slot *hostSlot) wait(ctx context.Context, delay time.Duration) error {
+	slot.mu.Lock()
+	now := time.Now()
+	start := now
+	if slot.next.After(now) {
+		start = slot.next
+	}
+	slot.next = start.Add(delay)
+	slot.mu.Unlock()
+
+	if wait := start.Sub(now); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			// waited long enough, continue
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+// getSlot returns the slot for a host, creating it if necessary.
+func (l *hostLimiter) getSlot(host string) *hostSlot {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	slot, exists := l.hosts[host]
+	if !exists {
+		slot = &hostSlot{semaphore: make(chan struct{}, l.jobs)}
+		l.hosts[host] = slot
+	}
+	return slot
+}
+
+// hostOf returns the lowercased host name of a URL, or the URL itself if it
+// can't be parsed.
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Hostname() == "" {
+		return rawURL
+	}
+	return strings.ToLower(u.Hostname())
+}
@@ -0,0 +1,275 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/jamielinux/feed-notifier/internal/config"
+	"github.com/jamielinux/feed-notifier/internal/netpolicy"
+)
+
+// initHTTPClients creates the HTTP client for each feed. Feeds with the same
+// proxy and TLS settings share a client.
+func (s *Service) initHTTPClients() error {
+	clients := make(map[string]*http.Client)
+
+	for _, feed := range s.config.Feeds {
+		key := httpClientKey(&feed.HTTPSettings)
+		client, exists := clients[key]
+		if !exists {
+			var err error
+			client, err = newHTTPClient(&feed.HTTPSettings, s.netPolicy)
+			if err != nil {
+				return fmt.Errorf("feed '%s': %w", feed.ID, err)
+			}
+			clients[key] = client
+		}
+		s.httpClients[feed.ID] = client
+	}
+
+	return nil
+}
+
+// httpClientKey returns a string that identifies the settings used to create
+// a HTTP client.
+func httpClientKey(settings *config.HTTPSettings) string {
+	return fmt.Sprintf("%q|%q|%q|%q|%t",
+		settings.Proxy,
+		strings.Join(settings.CAFiles, "\x00"),
+		settings.ClientCert,
+		settings.ClientKey,
+		settings.SkipVerify())
+}
+
+// newHTTPClient creates a HTTP client with the given proxy and TLS settings,
+// which only connects to addresses allowed by the network policy.
+func newHTTPClient(settings *config.HTTPSettings, policy *netpolicy.Policy) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = policy.DialContext()
+
+	switch settings.Proxy {
+	case "":
+		// Only the connection to a proxy could be checked, so proxies from
+		// the environment are ignored when private addresses are blocked.
+		if !policy.BlocksPrivate() {
+			transport.Proxy = http.ProxyFromEnvironment
+		} else {
+			transport.Proxy = nil
+		}
+	case config.ProxyDirect:
+		transport.Proxy = nil
+	default:
+		proxyURL, err := url.Parse(settings.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: settings.SkipVerify(),
+	}
+
+	if len(settings.CAFiles) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		for _, caFile := range settings.CAFiles {
+			pem, err := os.ReadFile(os.ExpandEnv(caFile))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read CA file: %w", err)
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in CA file '%s'", caFile)
+			}
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if settings.ClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(os.ExpandEnv(settings.ClientCert), os.ExpandEnv(settings.ClientKey))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport.TLSClientConfig = tlsConfig
+
+	// There's no client timeout because each feed has its own timeout,
+	// which is applied to the context of its requests.
+	return &http.Client{
+		Transport:     transport,
+		CheckRedirect: checkRedirect,
+	}, nil
+}
+
+// redirectTrackerKey is the context key for a redirectTracker.
+type redirectTrackerKey struct{}
+
+// redirectTracker records where a request was permanently redirected to.
+type redirectTracker struct {
+	// permanentURL is the last URL reached through only permanent redirects.
+	permanentURL string
+	temporary    bool
+}
+
+// withRedirectTracker returns a context that tracks the redirects of requests.
+func withRedirectTracker(ctx context.Context) (context.Context, *redirectTracker) {
+	tracker := &redirectTracker{}
+	return context.WithValue(ctx, redirectTrackerKey{}, tracker), tracker
+}
+
+// checkRedirect follows up to 10 redirects, like the default HTTP client, and
+// records permanent redirects if the request has a redirectTracker. The HTTP
+// client copies every header of the first request to a redirect, so the
+// headers and credentials of a feed are removed if it's redirected to another
+// host.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+
+	if feed, ok := req.Context().Value(feedKey{}).(*config.Feed); ok && !sameOrigin(req.URL.String(), feed.URL) {
+		removeHTTPSettings(req, feed)
+	}
+
+	tracker, ok := req.Context().Value(redirectTrackerKey{}).(*redirectTracker)
+	if !ok || tracker.temporary || req.Response == nil {
+		return nil
+	}
+
+	switch req.Response.StatusCode {
+	case http.StatusMovedPermanently, http.StatusPermanentRedirect:
+		tracker.permanentURL = req.URL.String()
+	default:
+		// The URL before a temporary redirect is still the canonical one.
+		tracker.temporary = true
+	}
+
+	return nil
+}
+
+// feedKey is the context key for the feed that a request is for.
+type feedKey struct{}
+
+// newFeedRequest creates a GET request for a feed with its HTTP settings. The
+// feed is stored in the context of the request for checkRedirect.
+func newFeedRequest(ctx context.Context, feed *config.Feed, rawURL string) (*http.Request, error) {
+	ctx = context.WithValue(ctx, feedKey{}, feed)
+	req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	applyHTTPSettings(req, feed)
+	return req, nil
+}
+
+// applyHTTPSettings adds the configured headers and credentials of a feed to
+// a request. Headers and credentials are only sent to the host of the feed's
+// configured url, so they aren't leaked to a host that it has moved to or
+// that it links to.
+func applyHTTPSettings(req *http.Request, feed *config.Feed) {
+	settings := &feed.HTTPSettings
+
+	if settings.UserAgent != "" {
+		req.Header.Set("User-Agent", settings.UserAgent)
+	}
+
+	if !sameOrigin(req.URL.String(), feed.URL) {
+		return
+	}
+
+	for name, value := range settings.Headers {
+		req.Header.Set(name, value)
+	}
+
+	for name, value := range settings.Cookies {
+		req.AddCookie(&http.Cookie{Name: name, Value: value})
+	}
+
+	if settings.BasicAuth.Username != "" {
+		req.SetBasicAuth(settings.BasicAuth.Username, settings.BasicAuth.Password)
+	} else if settings.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+settings.BearerToken)
+	}
+}
+
+// removeHTTPSettings removes the configured headers and credentials of a feed
+// from a request.
+func removeHTTPSettings(req *http.Request, feed *config.Feed) {
+	settings := &feed.HTTPSettings
+
+	for name := range settings.Headers {
+		req.Header.Del(name)
+	}
+
+	if len(settings.Cookies) > 0 {
+		req.Header.Del("Cookie")
+	}
+
+	if settings.BasicAuth.Username != "" || settings.BearerToken != "" {
+		req.Header.Del("Authorization")
+	}
+}
+
+// sameOrigin returns true if a URL has the same scheme and host as the url of
+// a feed. A feed that's upgraded from http to https on the same host keeps its
+// origin, since that's a common permanent redirect.
+func sameOrigin(rawURL, feedURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	origin, err := url.Parse(feedURL)
+	if err != nil {
+		return false
+	}
+
+	scheme, originScheme := strings.ToLower(u.Scheme), strings.ToLower(origin.Scheme)
+	if scheme != originScheme && (scheme != "https" || originScheme != "http") {
+		return false
+	}
+	return strings.EqualFold(hostWithoutDefaultPort(u), hostWithoutDefaultPort(origin))
+}
+
+// hostWithoutDefaultPort returns the host of a URL, without its port if it's
+// the default for the scheme.
+func hostWithoutDefaultPort(u *url.URL) string {
+	switch scheme := strings.ToLower(u.Scheme); u.Port() {
+	case "", defaultPorts[scheme]:
+		return u.Hostname()
+	default:
+		return u.Host
+	}
+}
+
+// defaultPorts are the default ports of the schemes of feed URLs.
+var defaultPorts = map[string]string{"http": "80", "https": "443"}
+
+// readBody reads a response body, failing if it's larger than maxBytes.
+func readBody(resp *http.Response, maxBytes int64) ([]byte, error) {
+	if resp.ContentLength > maxBytes {
+		return nil, fmt.Errorf("response body of %d bytes exceeds max_body_bytes (%d)", resp.ContentLength, maxBytes)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		return body, err
+	}
+	if int64(len(body)) > maxBytes {
+		return body, fmt.Errorf("response body exceeds max_body_bytes (%d)", maxBytes)
+	}
+
+	return body, nil
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/jamielinux/feed-notifier/internal/config"
+	"github.com/jamielinux/feed-notifier/internal/db"
+	"github.com/jamielinux/feed-notifier/internal/logger"
+	"github.com/mmcdole/gofeed"
+)
+
+const (
+	// maxStderrBytes is how much of the stderr of a command is kept for the
+	// error message when it fails.
+	maxStderrBytes = 4096
+
+	// commandWaitDelay is how long to wait for the output of a command to be
+	// closed after it exits or is killed.
+	commandWaitDelay = 5 * time.Second
+)
+
+// fetchLocal reads a feed from a file:// URL or the output of its command. It
+// returns http.StatusOK if the feed was read, or http.StatusNotModified if
+// it's unchanged since the last fetch, so that it's handled like a HTTP feed.
+func (s *Service) fetchLocal(feed *config.Feed, metadata *db.Feed, record *db.Fetch) (*gofeed.Feed, int, error) {
+	timeout := time.Duration(feed.Timeout) * time.Second
+	ctx, cancel := context.WithTimeout(s.ctx, timeout)
+	defer cancel()
+
+	var body []byte
+	var err error
+	if len(feed.Command) > 0 {
+		body, err = runCommand(ctx, feed.Command, feed.MaxBodyBytes)
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("command timed out after %s", timeout)
+		}
+	} else {
+		body, err = readFile(feed.URL, feed.MaxBodyBytes)
+	}
+	record.Bytes = int64(len(body))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	contentHash := hashContent(body)
+	if contentHash == metadata.ContentHash {
+		logger.Debug("Feed '%s' is unchanged since the last fetch", feed.ID)
+		return nil, http.StatusNotModified, nil
+	}
+
+	parsedFeed, err := s.parseFeed(feed, feed.URL, body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to parse feed: %w", err)
+	}
+	metadata.ContentHash = contentHash
+	updateFeedHints(metadata, parsedFeed)
+
+	return parsedFeed, http.StatusOK, nil
+}
+
+// readFile reads the file at a file:// URL, failing if it's larger than
+// maxBytes.
+func readFile(fileURL string, maxBytes int64) ([]byte, error) {
+	u, err := url.Parse(fileURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid file url: %w", err)
+	}
+
+	f, err := os.Open(u.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer f.Close()
+
+	body, err := io.ReadAll(io.LimitReader(f, maxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	if int64(len(body)) > maxBytes {
+		return body, fmt.Errorf("file exceeds max_body_bytes (%d)", maxBytes)
+	}
+
+	return body, nil
+}
+
+// runCommand runs a command and returns its output, failing if it exits with
+// an error or writes more than maxBytes. The command is run directly rather
+// than by a shell.
+func runCommand(ctx context.Context, argv []string, maxBytes int64) ([]byte, error) {
+	stdout := &limitedBuffer{max: maxBytes}
+	stderr := &limitedBuffer{max: maxStderrBytes, truncate: true}
+
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	// Killing the command doesn't kill any processes that it started, which
+	// may keep its output open, so stop waiting for them shortly after.
+	cmd.WaitDelay = commandWaitDelay
+
+	err := cmd.Run()
+	if stdout.exceeded {
+		return stdout.Bytes(), fmt.Errorf("command output exceeds max_body_bytes (%d)", maxBytes)
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return stdout.Bytes(), fmt.Errorf("command failed: %w: %s", err, msg)
+		}
+		return stdout.Bytes(), fmt.Errorf("command failed: %w", err)
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// limitedBuffer is a buffer that holds at most max bytes. Writes beyond that
+// fail, which stops the command, unless truncate is set, in which case the
+// rest is discarded. The buffer isn't embedded, so that io.Copy can't bypass
+// Write with bytes.Buffer's ReadFrom.
+type limitedBuffer struct {
+	buf      bytes.Buffer
+	max      int64
+	truncate bool
+	exceeded bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	remaining := b.max - int64(b.buf.Len())
+	if int64(len(p)) <= remaining {
+		return b.buf.Write(p)
+	}
+
+	b.exceeded = true
+	b.buf.Write(p[:max(remaining, 0)])
+	if b.truncate {
+		return len(p), nil
+	}
+	return 0, errors.New("output too large")
+}
+
+func (b *limitedBuffer) Bytes() []byte {
+	return b.buf.Bytes()
+}
+
+func (b *limitedBuffer) String() string {
+	return b.buf.String()
+}
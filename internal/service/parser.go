@@ -0,0 +1,101 @@
+package service
+
+import (
+	"strings"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/mmcdole/gofeed/atom"
+	"github.com/mmcdole/gofeed/rss"
+)
+
+// newFeedParser creates a feed parser that keeps the elements that gofeed
+// doesn't otherwise expose, in the Custom map of the feed:
+//   - "ttl", "skipHours" and "skipDays" for RSS feeds.
+//   - "hub" for the WebSub hub of RSS and Atom feeds.
+//   - "next" and "prev-archive" for the older pages of RSS and Atom feeds
+//     that are paged or archived as in RFC 5005.
+func newFeedParser() *gofeed.Parser {
+	parser := gofeed.NewParser()
+	parser.RSSTranslator = &rssTranslator{}
+	parser.AtomTranslator = &atomTranslator{}
+	return parser
+}
+
+// rssTranslator is a gofeed translator for RSS feeds.
+type rssTranslator struct {
+	gofeed.DefaultRSSTranslator
+}
+
+// Translate converts an RSS feed to the universal feed type.
+func (t *rssTranslator) Translate(feed interface{}) (*gofeed.Feed, error) {
+	result, err := t.DefaultRSSTranslator.Translate(feed)
+	if err != nil {
+		return nil, err
+	}
+
+	rssFeed, ok := feed.(*rss.Feed)
+	if !ok {
+		return result, nil
+	}
+
+	if result.Custom == nil {
+		result.Custom = make(map[string]string)
+	}
+	if rssFeed.TTL != "" {
+		result.Custom["ttl"] = rssFeed.TTL
+	}
+	if len(rssFeed.SkipHours) > 0 {
+		result.Custom["skipHours"] = strings.Join(rssFeed.SkipHours, ",")
+	}
+	if len(rssFeed.SkipDays) > 0 {
+		result.Custom["skipDays"] = strings.Join(rssFeed.SkipDays, ",")
+	}
+
+	// RSS feeds advertise their hub and older pages with atom:link elements,
+	// which gofeed stores as extensions.
+	for _, elements := range rssFeed.Extensions {
+		for _, link := range elements["link"] {
+			addLink(result.Custom, link.Attrs["rel"], link.Attrs["href"])
+		}
+	}
+
+	return result, nil
+}
+
+// atomTranslator is a gofeed translator for Atom feeds.
+type atomTranslator struct {
+	gofeed.DefaultAtomTranslator
+}
+
+// Translate converts an Atom feed to the universal feed type.
+func (t *atomTranslator) Translate(feed interface{}) (*gofeed.Feed, error) {
+	result, err := t.DefaultAtomTranslator.Translate(feed)
+	if err != nil {
+		return nil, err
+	}
+
+	atomFeed, ok := feed.(*atom.Feed)
+	if !ok {
+		return result, nil
+	}
+
+	if result.Custom == nil {
+		result.Custom = make(map[string]string)
+	}
+	for _, link := range atomFeed.Links {
+		addLink(result.Custom, link.Rel, link.Href)
+	}
+
+	return result, nil
+}
+
+// addLink stores the first link of each relation that's kept in the Custom
+// map of a feed.
+func addLink(custom map[string]string, rel, href string) {
+	switch rel {
+	case "hub", "next", "prev-archive":
+		if href != "" && custom[rel] == "" {
+			custom[rel] = href
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package service
+
+import (
+	"container/heap"
+	"context"
+	"log"
+	"math/rand/v2"
+	"time"
+
+	"github.com/jamielinux/feed-notifier/internal/config"
+	"github.com/jamielinux/feed-notifier/internal/db"
+	"github.com/jamielinux/feed-notifier/internal/logger"
+)
+
+// scheduledFeed is a feed in the schedule, along with its metadata.
+type scheduledFeed struct {
+	feed     *config.Feed
+	metadata *db.Feed
+	due      time.Time
+}
+
+// schedule is a min-heap of feeds ordered by when they're next due to be
+// fetched. It implements heap.Interface.
+type schedule []*scheduledFeed
+
+func (q schedule) Len() int           { return len(q) }
+func (q schedule) Less(i, j int) bool { return q[i].due.Before(q[j].due) }
+func (q schedule) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+
+func (q *schedule) Push(x any) {
+	*q = append(*q, x.(*scheduledFeed))
+}
+
+func (q *schedule) Pop() any {
+	old := *q
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+	return item
+}
+
+// initSchedule loads the metadata of every feed and creates the schedule.
+// Feeds that are already due are spread randomly over fetch.startup_spread,
+// so that they aren't all fetched at once.
+func (s *Service) initSchedule() *schedule {
+	queue := &schedule{}
+	now := time.Now()
+
+	for i := range s.config.Feeds {
+		feed := &s.config.Feeds[i]
+		item := &scheduledFeed{
+			feed:     feed,
+			metadata: s.getFeedMetadata(feed),
+		}
+		s.reschedule(queue, item, now)
+	}
+
+	if spread := time.Duration(s.config.Fetch.StartupSpread) * time.Second; spread > 0 {
+		for _, item := range *queue {
+			if !item.due.After(now) {
+				item.due = now.Add(rand.N(spread))
+				logger.Debug("Feed '%s' is staggered to %s", item.feed.ID, item.due.Format(time.RFC3339))
+			}
+		}
+		heap.Init(queue)
+	}
+
+	return queue
+}
+
+// reschedule adds a feed back to the schedule at its next due time, unless
+// it shouldn't be fetched again.
+func (s *Service) reschedule(queue *schedule, item *scheduledFeed, now time.Time) {
+	due, ok := s.nextFetch(item.feed, item.metadata, now)
+	if !ok {
+		logger.Debug("Feed '%s' will not be fetched again", item.feed.ID)
+		return
+	}
+
+	item.due = due
+	heap.Push(queue, item)
+	logger.Debug("Feed '%s' is next due at %s", item.feed.ID, due.Format(time.RFC3339))
+}
+
+// runScheduler fetches each feed when it's due until the service is stopped.
+// Feeds are fetched in the background, and are added back to the schedule
+// once they've been processed.
+func (s *Service) runScheduler(queue *schedule) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		wait := time.Hour
+		if queue.Len() > 0 {
+			wait = max(time.Until((*queue)[0].due), 0)
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-timer.C:
+			now := time.Now()
+			for queue.Len() > 0 && !(*queue)[0].due.After(now) {
+				s.dispatch(heap.Pop(queue).(*scheduledFeed))
+			}
+		case item := <-s.completed:
+			s.reschedule(queue, item, time.Now())
+		case <-s.ticker.C:
+			s.pruneFetches()
+			s.renewSubscriptions()
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// dispatch fetches and processes a feed in the background, then sends it back
+// to the scheduler.
+func (s *Service) dispatch(item *scheduledFeed) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		if !s.fetchWithLimits(item) {
+			// context was cancelled
+			return
+		}
+
+		select {
+		case s.completed <- item:
+			// rescheduled
+		case <-s.ctx.Done():
+			// context was cancelled
+		}
+	}()
+}
+
+// fetchWithLimits processes a feed once both the host and global concurrency
+// limits allow it. It returns false if the service was stopped while waiting.
+func (s *Service) fetchWithLimits(item *scheduledFeed) bool {
+	// Wait for the host before taking a global slot, so that feeds on a busy
+	// host don't hold up feeds on other hosts. Local feeds don't have a host.
+	if !item.feed.IsLocal() {
+		releaseHost, err := s.hostLimiter.acquire(s.ctx, getFetchURL(item.feed, item.metadata))
+		if err != nil {
+			return false
+		}
+		defer releaseHost()
+	}
+
+	select {
+	case s.semaphore <- struct{}{}:
+		// got a slot, continue processing
+	case <-s.ctx.Done():
+		return false
+	}
+	defer func() { <-s.semaphore }() // make sure to release the slot
+
+	lock := s.feedLocks[item.feed.ID]
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := s.processFeed(item.feed, item.metadata); err != nil {
+		log.Printf("Error processing feed '%s': %v", item.feed.ID, err)
+	}
+
+	return true
+}
+
+// acquireHost waits until an extra request of a feed, such as for an older
+// page, is allowed to the host of rawURL. The feed is fetched while holding a
+// slot for the host of hostURL, so a request to the same host only waits for
+// the delay, since waiting for another slot could wait forever.
+func (s *Service) acquireHost(ctx context.Context, hostURL, rawURL string) (func(), error) {
+	if hostOf(rawURL) == hostOf(hostURL) {
+		return func() {}, s.hostLimiter.wait(ctx, rawURL)
+	}
+	return s.hostLimiter.acquire(ctx, rawURL)
+}
+
+// pruneFetches deletes fetch history older than fetch.history_days.
+func (s *Service) pruneFetches() {
+	cutoff := time.Now().AddDate(0, 0, -s.config.Fetch.HistoryDays).Unix()
+	if deleted := s.db.PruneFetches(cutoff); deleted > 0 {
+		logger.Debug("Pruned %d fetch history records", deleted)
+	}
+}
@@ -1,7 +1,9 @@
 package service
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -10,8 +12,12 @@ import (
 
 	"github.com/jamielinux/feed-notifier/internal/config"
 	"github.com/jamielinux/feed-notifier/internal/db"
+	"github.com/jamielinux/feed-notifier/internal/discover"
+	"github.com/jamielinux/feed-notifier/internal/jsonapi"
 	"github.com/jamielinux/feed-notifier/internal/logger"
+	"github.com/jamielinux/feed-notifier/internal/netpolicy"
 	"github.com/jamielinux/feed-notifier/internal/notifier"
+	"github.com/jamielinux/feed-notifier/internal/scrape"
 	"github.com/mmcdole/gofeed"
 )
 
@@ -19,35 +25,70 @@ import (
 type Service struct {
 	config      *config.Config
 	db          *db.DB
-	httpClient  *http.Client
+	httpClients map[string]*http.Client
+	schedules   map[string]*feedSchedule
+	netPolicy   *netpolicy.Policy
 	parser      *gofeed.Parser
 	notifierMap map[string]notifier.Notifier
+	websub      *webSub
+
+	// feedLocks stop a feed from being processed by a fetch and a WebSub
+	// push at the same time.
+	feedLocks map[string]*sync.Mutex
+
+	// notifier health, for alerts
+	notifierMu       sync.Mutex
+	notifierFailures map[string]int
 
 	// concurrency
-	ctx       context.Context
-	cancel    context.CancelFunc
-	ticker    *time.Ticker
-	semaphore chan struct{}
-	wg        sync.WaitGroup
+	ctx         context.Context
+	cancel      context.CancelFunc
+	ticker      *time.Ticker
+	semaphore   chan struct{}
+	hostLimiter *hostLimiter
+	completed   chan *scheduledFeed
+	wg          sync.WaitGroup
 }
 
 // New creates a Service instance.
 func New(config *config.Config, database *db.DB) (*Service, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 	semaphore := make(chan struct{}, config.Fetch.Jobs)
+	hostLimiter := newHostLimiter(config.Fetch.HostJobs, time.Duration(config.Fetch.HostDelay)*time.Second)
 
 	service := &Service{
 		config:      config,
 		db:          database,
-		httpClient:  &http.Client{Timeout: 30 * time.Second},
-		parser:      gofeed.NewParser(),
+		httpClients: make(map[string]*http.Client),
+		schedules:   make(map[string]*feedSchedule),
+		netPolicy:   netpolicy.New(&config.NetworkPolicy),
+		parser:      newFeedParser(),
 		notifierMap: make(map[string]notifier.Notifier),
+		feedLocks:   make(map[string]*sync.Mutex),
+
+		notifierFailures: make(map[string]int),
 
 		// concurrency
-		ctx:       ctx,
-		cancel:    cancel,
-		ticker:    time.NewTicker(1 * time.Minute),
-		semaphore: semaphore,
+		ctx:         ctx,
+		cancel:      cancel,
+		ticker:      time.NewTicker(1 * time.Hour),
+		semaphore:   semaphore,
+		hostLimiter: hostLimiter,
+		completed:   make(chan *scheduledFeed),
+	}
+
+	for _, feed := range config.Feeds {
+		service.feedLocks[feed.ID] = &sync.Mutex{}
+	}
+
+	if err := service.initHTTPClients(); err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to initialize HTTP clients: %w", err)
+	}
+
+	if err := service.initSchedules(); err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to initialize schedules: %w", err)
 	}
 
 	if err := service.initNotifiers(); err != nil {
@@ -55,6 +96,11 @@ func New(config *config.Config, database *db.DB) (*Service, error) {
 		return nil, fmt.Errorf("failed to initialize notifiers: %w", err)
 	}
 
+	if err := service.initWebSub(); err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to initialize WebSub: %w", err)
+	}
+
 	return service, nil
 }
 
@@ -63,8 +109,15 @@ func (s *Service) initNotifiers() error {
 	// Add the default built-in stdout notifier.
 	s.notifierMap["stdout"] = notifier.NewStdout()
 
-	// Add notifiers from config file.
-	factory := notifier.NewFactory()
+	// Add notifiers from config file. They use the default proxy settings
+	// from the environment, but are subject to the network policy.
+	client, err := newHTTPClient(&config.HTTPSettings{}, s.netPolicy)
+	if err != nil {
+		return err
+	}
+	client.Timeout = 30 * time.Second
+
+	factory := notifier.NewFactory(client)
 	for _, n := range s.config.Notifiers {
 		notifierInstance, err := factory.Create(&n)
 		if err != nil {
@@ -79,18 +132,16 @@ func (s *Service) initNotifiers() error {
 // Start starts the service.
 func (s *Service) Start() {
 	logger.Debug("Starting service...")
+	queue := s.initSchedule()
+	s.pruneFetches()
+	if s.websub != nil {
+		s.startWebSub()
+	}
+
 	s.wg.Add(1)
 	go func() {
 		defer s.wg.Done()
-		s.processAllFeeds()
-		for {
-			select {
-			case <-s.ticker.C:
-				s.processAllFeeds()
-			case <-s.ctx.Done():
-				return
-			}
-		}
+		s.runScheduler(queue)
 	}()
 }
 
@@ -98,141 +149,311 @@ func (s *Service) Start() {
 func (s *Service) Stop() {
 	logger.Debug("Stopping service...")
 	s.ticker.Stop()
+	if s.websub != nil {
+		s.stopWebSub()
+	}
 	s.cancel()
 	s.wg.Wait()
 }
 
-// processAllFeeds checks all feeds and fetches any that are due to be fetched.
-func (s *Service) processAllFeeds() {
-	var wg sync.WaitGroup
-
-	for _, feed := range s.config.Feeds {
-		select {
-		case <-s.ctx.Done():
-			// context was cancelled
-			return
-		default:
-			// continue
-		}
-
-		feedCopy := feed
-		metadata := s.db.GetFeed(feed.ID)
-		now := time.Now().Unix()
-		if !s.shouldFetchFeed(&feedCopy, metadata, now) {
-			continue
-		}
-
-		select {
-		case s.semaphore <- struct{}{}:
-			// got a slot, continue processing
-		case <-s.ctx.Done():
-			// context was cancelled while waiting for a slot
-			return
+// processFeed handles fetching and processing a single feed. The metadata is
+// updated in place and saved to the database.
+func (s *Service) processFeed(feed *config.Feed, metadata *db.Feed) error {
+	logger.Debug("Processing feed: %s (%s)", feed.ID, feed.Source())
+
+	start := time.Now()
+	record := &db.Fetch{FeedID: feed.ID, Timestamp: start.Unix()}
+	defer func() {
+		if s.ctx.Err() == nil {
+			record.DurationMs = time.Since(start).Milliseconds()
+			s.db.LogFetch(record)
 		}
+	}()
 
-		wg.Add(1)
-		go func(f config.Feed) {
-			defer wg.Done()
-			defer func() { <-s.semaphore }() // make sure to release the slot
-			if err := s.processFeed(&f); err != nil {
-				log.Printf("Error processing feed '%s': %v", f.ID, err)
-			}
-		}(feedCopy)
-	}
+	// LastChecked is only set after a successful fetch, so a feed that has
+	// only ever failed is still on its first run.
+	firstRun := metadata.LastChecked == 0
+	lastChecked := metadata.LastChecked
 
-	wg.Wait()
-	logger.Debug("Finished processing feeds")
-}
+	// The validators of the last response are kept until the articles in a
+	// new response have been sent, so that if any notifications fail, the
+	// feed isn't skipped as unchanged next time and they're retried.
+	etag, lastModified, contentHash := metadata.ETag, metadata.LastModified, metadata.ContentHash
 
-// processFeed handles fetching and processing a single feed.
-func (s *Service) processFeed(feed *config.Feed) error {
-	logger.Debug("Processing feed: %s (%s)", feed.ID, feed.URL)
+	// The host limiter slot is for the URL that the feed was fetched from
+	// before any redirect or discovery is recorded.
+	hostURL := getFetchURL(feed, metadata)
 
-	metadata, firstRun := s.getFeedMetadata(feed)
-
-	parsedFeed, httpStatus, err := s.fetchFeed(feed, metadata)
+	parsedFeed, httpStatus, err := s.fetchFeed(feed, metadata, record, true)
 	if err != nil {
-		return fmt.Errorf("fetch error: %w", err)
+		if s.ctx.Err() != nil {
+			// The service is stopping, so this isn't a failure of the feed.
+			return nil
+		}
+		record.Error = err.Error()
+		var statusErr *statusError
+		if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusGone {
+			s.markFeedGone(feed, metadata)
+			return nil
+		}
+		delay := s.recordFailure(feed, metadata, err)
+		return fmt.Errorf("fetch error (%d consecutive failures, retrying in %s): %w",
+			metadata.Failures, delay, err)
 	}
 
+	s.alertFeedRecovery(feed, metadata)
 	metadata.LastChecked = time.Now().Unix()
-	s.db.UpdateFeed(metadata)
+	metadata.NotBefore = 0
+	metadata.Failures = 0
+	metadata.FailingSince = 0
+	metadata.Alerted = false
+	metadata.GoneURL = ""
 
 	if httpStatus == http.StatusNotModified {
+		s.db.UpdateFeed(metadata)
 		return nil
 	}
 
+	newETag, newLastModified, newContentHash := metadata.ETag, metadata.LastModified, metadata.ContentHash
+	if !firstRun {
+		metadata.ETag, metadata.LastModified, metadata.ContentHash = etag, lastModified, contentHash
+	}
+	s.db.UpdateFeed(metadata)
+
 	if firstRun {
+		record.Items = len(parsedFeed.Items)
 		logger.Debug("First fetch for feed '%s', logging %d articles without sending notifications",
 			feed.ID, len(parsedFeed.Items))
 		s.logItems(feed, parsedFeed.Items)
 		return nil
 	}
 
-	return s.processArticles(feed, parsedFeed.Items)
+	if *feed.MaxPages > 0 && feed.Type == config.TypeFeed && !feed.IsLocal() {
+		s.fetchOlderPages(feed, metadata, parsedFeed, hostURL, lastChecked)
+	}
+	record.Items = len(parsedFeed.Items)
+
+	record.NewItems, err = s.processArticles(feed, parsedFeed.Items)
+	if err != nil {
+		return err
+	}
+
+	metadata.ETag, metadata.LastModified, metadata.ContentHash = newETag, newLastModified, newContentHash
+	s.db.UpdateFeed(metadata)
+	return nil
 }
 
-// fetchFeed retrieves and parses a feed from its URL.
-func (s *Service) fetchFeed(feed *config.Feed, metadata *db.Feed) (*gofeed.Feed, int, error) {
-	req, err := http.NewRequestWithContext(s.ctx, "GET", feed.URL, nil)
+// fetchFeed retrieves and parses a feed from its URL. The HTTP status and size
+// of the response are stored in record. If allowDiscovery is true and the URL
+// is an HTML page, the feed that it links to is used instead. Feeds with a
+// file:// URL or a command are read locally.
+func (s *Service) fetchFeed(feed *config.Feed, metadata *db.Feed, record *db.Fetch, allowDiscovery bool) (*gofeed.Feed, int, error) {
+	if feed.IsLocal() {
+		return s.fetchLocal(feed, metadata, record)
+	}
+
+	fetchURL := getFetchURL(feed, metadata)
+	timeout := time.Duration(feed.Timeout) * time.Second
+	ctx, cancel := context.WithTimeout(s.ctx, timeout)
+	defer cancel()
+	ctx, redirects := withRedirectTracker(ctx)
+
+	req, err := newFeedRequest(ctx, feed, fetchURL)
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to create request: %w", err)
 	}
 
-	if metadata.ETag != "" {
+	fullFetch := s.needsHubCheck(feed)
+	if metadata.ETag != "" && !fullFetch {
 		req.Header.Add("If-None-Match", metadata.ETag)
-	} else if metadata.LastModified != "" {
+	}
+	if metadata.LastModified != "" && !fullFetch {
 		req.Header.Add("If-Modified-Since", metadata.LastModified)
 	}
 
-	resp, err := s.httpClient.Do(req)
+	resp, err := s.httpClients[feed.ID].Do(req)
 	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, 0, fmt.Errorf("HTTP request timed out after %s", timeout)
+		}
 		return nil, 0, fmt.Errorf("HTTP request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
-	if etag := resp.Header.Get("ETag"); etag != "" {
-		metadata.ETag = etag
-	}
-
-	if lastModified := resp.Header.Get("Last-Modified"); lastModified != "" {
-		metadata.LastModified = lastModified
-	}
-
-	cacheControl := resp.Header.Get("Cache-Control")
-	metadata.MaxAge = parseMaxAge(cacheControl, 14400) // 4 hours max
+	record.HTTPStatus = resp.StatusCode
 
 	switch resp.StatusCode {
 	case http.StatusOK:
-		parsedFeed, err := s.parser.Parse(resp.Body)
+		body, err := readBody(resp, feed.MaxBodyBytes)
+		record.Bytes = int64(len(body))
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, resp.StatusCode, fmt.Errorf("reading response timed out after %s", timeout)
+		}
+		if err != nil {
+			return nil, resp.StatusCode, fmt.Errorf("failed to read response: %w", err)
+		}
+
+		// Servers without validators return the whole feed every time, so
+		// skip parsing if it's byte-identical to the last response.
+		contentHash := hashContent(body)
+		if contentHash == metadata.ContentHash && !fullFetch {
+			logger.Debug("Feed '%s' is unchanged since the last fetch", feed.ID)
+			updateCacheMetadata(metadata, resp)
+			recordRedirect(feed, metadata, fetchURL, redirects)
+			return nil, http.StatusNotModified, nil
+		}
+
+		parsedFeed, err := s.parseFeed(feed, resp.Request.URL.String(), body)
+		if err != nil && allowDiscovery && feed.Type == config.TypeFeed &&
+			discover.IsHTML(resp.Header.Get("Content-Type"), body) {
+			return s.discoverFeed(feed, metadata, record, resp, body)
+		}
 		if err != nil {
 			return nil, resp.StatusCode, fmt.Errorf("failed to parse feed: %w", err)
 		}
+		metadata.ContentHash = contentHash
+		updateFeedHints(metadata, parsedFeed)
+		updateCacheMetadata(metadata, resp)
+		recordRedirect(feed, metadata, fetchURL, redirects)
+		s.checkSubscription(feed, parsedFeed, resp.Header, fetchURL)
 		return parsedFeed, resp.StatusCode, nil
 	case http.StatusNotModified:
+		updateCacheMetadata(metadata, resp)
+		recordRedirect(feed, metadata, fetchURL, redirects)
 		return nil, http.StatusNotModified, nil
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now(), 86400) // 24 hours max
+		return nil, resp.StatusCode, &statusError{StatusCode: resp.StatusCode, RetryAfter: retryAfter}
 	default:
-		return nil, resp.StatusCode, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return nil, resp.StatusCode, &statusError{StatusCode: resp.StatusCode}
+	}
+}
+
+// parseFeed parses the body of a response according to the type of the feed.
+// The page URL is used to resolve relative links.
+func (s *Service) parseFeed(feed *config.Feed, pageURL string, body []byte) (*gofeed.Feed, error) {
+	switch feed.Type {
+	case config.TypeScrape:
+		return scrape.Parse(body, pageURL, &feed.Scrape, s.schedules[feed.ID].location)
+	case config.TypeJSON:
+		return jsonapi.Parse(body, pageURL, &feed.JSON, s.schedules[feed.ID].location)
+	default:
+		return s.parser.Parse(bytes.NewReader(body))
+	}
+}
+
+// discoverFeed finds the feed linked from an HTML page and fetches it. The
+// feed is stored as a redirect, so it's fetched directly in future.
+func (s *Service) discoverFeed(feed *config.Feed, metadata *db.Feed, record *db.Fetch,
+	resp *http.Response, body []byte) (*gofeed.Feed, int, error) {
+	pageURL := resp.Request.URL.String()
+	candidates, err := discover.Find(body, pageURL)
+	if err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("failed to parse HTML page: %w", err)
+	}
+	if len(candidates) == 0 {
+		return nil, resp.StatusCode, fmt.Errorf("%s is an HTML page without any feed links", pageURL)
+	}
+
+	feedURL := candidates[0].URL
+	log.Printf("Feed '%s' is an HTML page, using the feed that it links to: %s (consider updating its url in the config)",
+		feed.ID, feedURL)
+
+	// The page was fetched while holding a host limiter slot for its URL,
+	// which is still the fetch URL of the feed.
+	ctx, cancel := context.WithTimeout(s.ctx, time.Duration(feed.Timeout)*time.Second)
+	defer cancel()
+	releaseHost, err := s.acquireHost(ctx, getFetchURL(feed, metadata), feedURL)
+	if err != nil {
+		return nil, resp.StatusCode, fmt.Errorf("failed to wait for host of discovered feed: %w", err)
+	}
+	defer releaseHost()
+
+	// Only keep the discovered URL if the feed can be fetched from it.
+	redirectSource, redirectURL := metadata.RedirectSource, metadata.RedirectURL
+	metadata.RedirectSource = feed.URL
+	metadata.RedirectURL = feedURL
+
+	parsedFeed, status, err := s.fetchFeed(feed, metadata, record, false)
+	if err != nil {
+		metadata.RedirectSource, metadata.RedirectURL = redirectSource, redirectURL
 	}
+	return parsedFeed, status, err
+}
+
+// recordRedirect stores the URL that a feed has permanently moved to, so that
+// it's fetched from there in future.
+func recordRedirect(feed *config.Feed, metadata *db.Feed, fetchURL string, redirects *redirectTracker) {
+	if redirects.permanentURL == "" || redirects.permanentURL == fetchURL {
+		return
+	}
+
+	log.Printf("Feed '%s' has permanently moved to %s, consider updating its url in the config",
+		feed.ID, redirects.permanentURL)
+	metadata.RedirectSource = feed.URL
+	metadata.RedirectURL = redirects.permanentURL
 }
 
-// processArticles handles new articles in a feed and sends notifications.
-func (s *Service) processArticles(feed *config.Feed, articles []*gofeed.Item) error {
+// updateCacheMetadata stores the caching headers of a successful response.
+func updateCacheMetadata(metadata *db.Feed, resp *http.Response) {
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		metadata.ETag = etag
+	}
+
+	if lastModified := resp.Header.Get("Last-Modified"); lastModified != "" {
+		metadata.LastModified = lastModified
+	}
+
+	cacheControl := resp.Header.Get("Cache-Control")
+	metadata.MaxAge = parseMaxAge(cacheControl, 14400) // 4 hours max
+}
+
+// processArticles handles new articles in a feed and sends notifications. It
+// returns the number of notifications sent, and an error if any failed.
+func (s *Service) processArticles(feed *config.Feed, articles []*gofeed.Item) (int, error) {
 	notifierInstance := s.getNotifierForFeed(feed)
+	now := time.Now()
+	sent, failed := 0, 0
+
+	if feed.Order == config.OrderChronological {
+		articles = sortArticles(articles)
+	}
 
 	for _, item := range articles {
-		articleID := s.getArticleID(item)
+		articleID := s.getArticleID(feed, item)
 		if articleID == "" {
 			continue
 		}
 		if s.db.IsArticleNew(feed.ID, articleID) {
+			if isArticleStale(feed, item, now) {
+				log.Printf("Ignoring stale article '%s' in feed '%s' (older than %d hours)",
+					articleID, feed.ID, *feed.MaxAge)
+				s.logItem(feed, item, articleID)
+				continue
+			}
+			if feed.DedupScope != "" && !s.db.ClaimArticle(feed.DedupScope, feed.Notifier, articleID) {
+				logger.Debug("Article '%s' in feed '%s' was already sent in dedup scope '%s'",
+					articleID, feed.ID, feed.DedupScope)
+				s.db.LogArticle(feed.ID, articleID, getArticleTimestamp(item, now))
+				continue
+			}
 			if err := notifierInstance.Notify(feed, item); err != nil {
 				log.Printf("Failed to send notification for '%s': %v", articleID, err)
+				if feed.DedupScope != "" {
+					s.db.ReleaseArticle(feed.DedupScope, feed.Notifier, articleID)
+				}
+				s.recordNotifierResult(feed, err)
+				failed++
 				continue
 			}
-			s.db.LogArticle(feed.ID, articleID)
+			s.recordNotifierResult(feed, nil)
+			s.db.LogArticle(feed.ID, articleID, getArticleTimestamp(item, now))
+			sent++
 		}
 	}
 
-	return nil
+	if failed > 0 {
+		return sent, fmt.Errorf("failed to send %d of %d notifications", failed, sent+failed)
+	}
+	return sent, nil
 }
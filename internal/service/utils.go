@@ -1,8 +1,17 @@
 package service
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/jamielinux/feed-notifier/internal/config"
 	"github.com/jamielinux/feed-notifier/internal/db"
@@ -10,24 +19,82 @@ import (
 	"github.com/mmcdole/gofeed"
 )
 
+// statusError is returned when a feed responds with an unexpected HTTP status.
+type statusError struct {
+	StatusCode int
+	RetryAfter time.Duration
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("unexpected status code: %d", e.StatusCode)
+}
+
+// hashContent returns a hash of the body of a response.
+func hashContent(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
 // getFeedMetadata gets or creates feed metadata.
-func (s *Service) getFeedMetadata(feed *config.Feed) (*db.Feed, bool) {
+func (s *Service) getFeedMetadata(feed *config.Feed) *db.Feed {
 	metadata := s.db.GetFeed(feed.ID)
 
-	firstRun := false
 	if metadata == nil {
-		firstRun = true
 		metadata = &db.Feed{
 			FeedID:      feed.ID,
 			LastChecked: 0,
 		}
 	}
 
-	return metadata, firstRun
+	return metadata
 }
 
-// getArticleID returns a unique identifier for the given article.
-func (s *Service) getArticleID(item *gofeed.Item) string {
+// getFetchURL returns the URL to fetch a feed from, which is where it has
+// permanently moved to if its configured URL has been redirected.
+func getFetchURL(feed *config.Feed, metadata *db.Feed) string {
+	if metadata != nil && metadata.RedirectURL != "" && metadata.RedirectSource == feed.URL {
+		return metadata.RedirectURL
+	}
+	return feed.URL
+}
+
+// markFeedGone records that a feed returned 410 Gone so that it's no longer
+// fetched, unless its configured URL changes.
+func (s *Service) markFeedGone(feed *config.Feed, metadata *db.Feed) {
+	log.Printf("Feed '%s' returned 410 Gone and will no longer be fetched, consider removing it from the config",
+		feed.ID)
+
+	metadata.GoneURL = feed.URL
+	s.db.UpdateFeed(metadata)
+
+	s.sendAlert(feed,
+		fmt.Sprintf("Feed '%s' is gone", feed.DisplayName),
+		fmt.Sprintf("The feed '%s' (%s) returned 410 Gone and will no longer be fetched.",
+			feed.ID, getFetchURL(feed, metadata)))
+}
+
+// getArticleID returns a unique identifier for the given article, using the
+// feed's configured article_id strategy.
+func (s *Service) getArticleID(feed *config.Feed, item *gofeed.Item) string {
+	var id string
+
+	switch feed.ArticleID {
+	case config.ArticleIDGUID:
+		id = item.GUID
+	case config.ArticleIDLink:
+		id = item.Link
+	case config.ArticleIDTitle:
+		id = item.Title
+	case config.ArticleIDHash:
+		id = hashArticle(item, feed.ArticleIDFields)
+	case config.ArticleIDNormalizedLink:
+		id = normalizeLink(item.Link, feed.ArticleIDStripParams)
+	}
+
+	if id != "" {
+		return id
+	}
+
 	if item.GUID != "" {
 		return item.GUID
 	}
@@ -40,6 +107,63 @@ func (s *Service) getArticleID(item *gofeed.Item) string {
 	return ""
 }
 
+// getArticleTime returns the most recent of the published and updated dates
+// of an article, or nil if the feed provides neither.
+func getArticleTime(item *gofeed.Item) *time.Time {
+	t := item.PublishedParsed
+	if item.UpdatedParsed != nil && (t == nil || item.UpdatedParsed.After(*t)) {
+		t = item.UpdatedParsed
+	}
+	return t
+}
+
+// getArticleTimestamp returns the time of an article as a Unix timestamp for
+// storing in the database. Articles without a date, or dated in the future,
+// get the current time.
+func getArticleTimestamp(item *gofeed.Item, now time.Time) int64 {
+	if t := getArticleTime(item); t != nil && t.Before(now) {
+		return t.Unix()
+	}
+	return now.Unix()
+}
+
+// isArticleStale determines if an article is older than the feed's max_age.
+// Articles without a date are never considered stale.
+func isArticleStale(feed *config.Feed, item *gofeed.Item, now time.Time) bool {
+	if *feed.MaxAge <= 0 {
+		return false
+	}
+
+	t := getArticleTime(item)
+	if t == nil {
+		return false
+	}
+
+	cutoff := now.Add(-time.Duration(*feed.MaxAge) * time.Hour)
+	return t.Before(cutoff)
+}
+
+// sortArticles returns a copy of the articles sorted oldest first. Articles
+// with the same date keep their feed order, and articles without a date are
+// placed last.
+func sortArticles(articles []*gofeed.Item) []*gofeed.Item {
+	sorted := make([]*gofeed.Item, len(articles))
+	copy(sorted, articles)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		ti, tj := getArticleTime(sorted[i]), getArticleTime(sorted[j])
+		if ti == nil {
+			return false
+		}
+		if tj == nil {
+			return true
+		}
+		return ti.Before(*tj)
+	})
+
+	return sorted
+}
+
 // parseMaxAge parses Cache-Control max-age header.
 func parseMaxAge(cacheControl string, maximum int64) int64 {
 	if cacheControl == "" {
@@ -72,38 +196,138 @@ func parseMaxAge(cacheControl string, maximum int64) int64 {
 	return maxAge
 }
 
-// shouldFetchFeed determines if we should make a HTTP request for this feed.
-func (s *Service) shouldFetchFeed(feed *config.Feed, metadata *db.Feed, now int64) bool {
-	if metadata == nil {
-		return true
+// parseRetryAfter parses a Retry-After header, which is either a number of
+// seconds or a HTTP date.
+func parseRetryAfter(retryAfter string, now time.Time, maximum int64) time.Duration {
+	retryAfter = strings.TrimSpace(retryAfter)
+	if retryAfter == "" {
+		return 0
 	}
 
-	if metadata.MaxAge > 0 {
-		expiryTime := metadata.LastChecked + metadata.MaxAge
-		if now < expiryTime {
-			return false
+	seconds, err := strconv.ParseInt(retryAfter, 10, 64)
+	if err != nil {
+		t, err := http.ParseTime(retryAfter)
+		if err != nil {
+			return 0
 		}
+		seconds = int64(t.Sub(now).Seconds())
 	}
 
-	interval := int64(feed.Interval * 60)
-	if (now - metadata.LastChecked) < interval {
-		return false
+	if seconds < 0 {
+		seconds = 0
+	}
+	if seconds > maximum {
+		seconds = maximum
 	}
 
-	return true
+	return time.Duration(seconds) * time.Second
+}
+
+// backoffDelay returns how long to wait before fetching a feed again after
+// the given number of consecutive failures. The delay starts at one minute
+// and doubles after each failure, up to the maximum.
+func backoffDelay(failures int, maximum time.Duration) time.Duration {
+	delay := time.Minute
+	for i := 1; i < failures && delay < maximum; i++ {
+		delay *= 2
+	}
+	return min(delay, maximum)
+}
+
+// recordFailure records a failed fetch and schedules the next attempt using
+// exponential backoff, or the server's Retry-After if that is later. It
+// returns the delay until the next attempt.
+func (s *Service) recordFailure(feed *config.Feed, metadata *db.Feed, err error) time.Duration {
+	now := time.Now()
+
+	metadata.Failures++
+	if metadata.Failures == 1 || metadata.FailingSince == 0 {
+		metadata.FailingSince = now.Unix()
+	}
+	delay := backoffDelay(metadata.Failures, time.Duration(s.config.Fetch.MaxBackoff)*time.Minute)
+
+	var statusErr *statusError
+	if errors.As(err, &statusErr) && statusErr.RetryAfter > delay {
+		delay = statusErr.RetryAfter
+	}
+
+	metadata.NotBefore = now.Add(delay).Unix()
+	s.alertFeedFailure(feed, metadata, err, now)
+	s.db.UpdateFeed(metadata)
+
+	return delay
+}
+
+// nextFetch returns when a feed is next due to be fetched, taking into
+// account its interval or cron schedule, its active window, the Cache-Control
+// max-age of the last response and any backoff. With an automatic interval,
+// the feed's own skipHours and skipDays are also honoured. A random delay of
+// up to fetch.jitter is added. It returns false if the feed shouldn't be
+// fetched again.
+func (s *Service) nextFetch(feed *config.Feed, metadata *db.Feed, now time.Time) (time.Time, bool) {
+	if metadata.GoneURL != "" && metadata.GoneURL == feed.URL {
+		return time.Time{}, false
+	}
+
+	schedule := s.schedules[feed.ID]
+
+	due := now
+	if metadata.LastChecked > 0 {
+		lastChecked := time.Unix(metadata.LastChecked, 0)
+		if len(schedule.crons) > 0 {
+			due = schedule.nextCron(lastChecked)
+			if due.IsZero() {
+				return time.Time{}, false
+			}
+		} else if feed.Interval == config.IntervalAuto {
+			due = lastChecked.Add(s.autoInterval(feed, metadata, now))
+		} else {
+			due = lastChecked.Add(feed.Interval.Duration())
+		}
+
+		if metadata.MaxAge > 0 {
+			expiryTime := lastChecked.Add(time.Duration(metadata.MaxAge) * time.Second)
+			if expiryTime.After(due) {
+				due = expiryTime
+			}
+		}
+	}
+
+	if notBefore := time.Unix(metadata.NotBefore, 0); notBefore.After(due) {
+		due = notBefore
+	}
+
+	// Add jitter to all but the first fetch, which is staggered separately by
+	// initSchedule.
+	if jitter := time.Duration(s.config.Fetch.Jitter) * time.Second; jitter > 0 && metadata.LastChecked > 0 {
+		due = due.Add(rand.N(jitter))
+	}
+
+	if feed.Interval == config.IntervalAuto && len(schedule.crons) == 0 {
+		due = nextUnskipped(due, metadata)
+	}
+
+	return schedule.nextActive(due), true
 }
 
 // logItems logs items as processed without sending notifications.
 func (s *Service) logItems(feed *config.Feed, items []*gofeed.Item) {
 	for _, item := range items {
-		articleID := s.getArticleID(item)
+		articleID := s.getArticleID(feed, item)
 		if articleID == "" {
 			continue
 		}
-		s.db.LogArticle(feed.ID, articleID)
+		s.logItem(feed, item, articleID)
 	}
 }
 
+// logItem logs a single item as processed without sending a notification.
+// It isn't claimed in the feed's dedup scope, since that's only for articles
+// that were sent, so other feeds in the scope can still send it.
+func (s *Service) logItem(feed *config.Feed, item *gofeed.Item, articleID string) {
+	s.db.LogArticle(feed.ID, articleID, getArticleTimestamp(item, time.Now()))
+}
+
 // getNotifierForFeed returns the configured notifier for a feed.
 func (s *Service) getNotifierForFeed(feed *config.Feed) notifier.Notifier {
 	return s.notifierMap[feed.Notifier]
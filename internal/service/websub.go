@@ -0,0 +1,511 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jamielinux/feed-notifier/internal/config"
+	"github.com/jamielinux/feed-notifier/internal/db"
+	"github.com/jamielinux/feed-notifier/internal/logger"
+	"github.com/mmcdole/gofeed"
+)
+
+const (
+	// websubRenewBefore is how long before a lease expires that it's renewed.
+	websubRenewBefore = 24 * time.Hour
+
+	// websubRetry is how long to wait for a hub to verify a subscription
+	// before requesting it again.
+	websubRetry = 6 * time.Hour
+)
+
+// webSub receives WebSub (PubSubHubbub) pushes from hubs. Feeds are still
+// polled as normal, so if a subscription fails or expires nothing is missed.
+type webSub struct {
+	path     string // path of the callback URL, ending with "/"
+	server   *http.Server
+	listener net.Listener
+	feeds    map[string]*config.Feed // feeds with websub enabled
+
+	// mu serialises changes to subscriptions.
+	mu sync.Mutex
+
+	// unchecked is the feeds that aren't subscribed and haven't been fetched
+	// in full since startup, so their hub hasn't been looked for yet.
+	unchecked   map[string]bool
+	uncheckedMu sync.Mutex
+}
+
+// initWebSub starts listening for WebSub callbacks, if websub.callback_url is
+// set.
+func (s *Service) initWebSub() error {
+	if s.config.WebSub.CallbackURL == "" {
+		return nil
+	}
+
+	callbackURL, err := url.Parse(s.config.WebSub.CallbackURL)
+	if err != nil {
+		return fmt.Errorf("invalid callback URL: %w", err)
+	}
+
+	listener, err := net.Listen("tcp", s.config.WebSub.Listen)
+	if err != nil {
+		return err
+	}
+
+	ws := &webSub{
+		path:      strings.TrimSuffix(callbackURL.Path, "/") + "/",
+		listener:  listener,
+		feeds:     make(map[string]*config.Feed),
+		unchecked: make(map[string]bool),
+	}
+	for i := range s.config.Feeds {
+		if feed := &s.config.Feeds[i]; *feed.WebSub {
+			ws.feeds[feed.ID] = feed
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(ws.path, s.handleWebSub)
+	ws.server = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	s.websub = ws
+	return nil
+}
+
+// startWebSub serves WebSub callbacks in the background, and removes the
+// subscriptions of feeds that no longer have websub enabled. Feeds that
+// aren't subscribed yet are fetched in full next, to find their hub.
+func (s *Service) startWebSub() {
+	logger.Debug("Listening for WebSub callbacks on %s", s.websub.listener.Addr())
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if err := s.websub.server.Serve(s.websub.listener); !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("WebSub callback server failed: %v", err)
+		}
+	}()
+
+	s.websub.uncheckedMu.Lock()
+	for id := range s.websub.feeds {
+		if s.db.GetSubscription(id) == nil {
+			s.websub.unchecked[id] = true
+		}
+	}
+	s.websub.uncheckedMu.Unlock()
+
+	for _, sub := range s.db.GetSubscriptions() {
+		if _, ok := s.websub.feeds[sub.FeedID]; ok {
+			continue
+		}
+		s.db.DeleteSubscription(sub.FeedID)
+		for i := range s.config.Feeds {
+			if feed := &s.config.Feeds[i]; feed.ID == sub.FeedID {
+				s.goSubscriptionRequest(feed, "unsubscribe", sub.Hub, sub.Topic, "")
+			}
+		}
+	}
+}
+
+// stopWebSub stops serving WebSub callbacks, waiting briefly for any that are
+// in progress.
+func (s *Service) stopWebSub() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.websub.server.Shutdown(ctx); err != nil {
+		log.Printf("Failed to stop WebSub callback server: %v", err)
+	}
+}
+
+// callbackURL returns the WebSub callback URL of a feed.
+func (s *Service) callbackURL(feed *config.Feed) string {
+	return strings.TrimSuffix(s.config.WebSub.CallbackURL, "/") + "/" + url.PathEscape(feed.ID)
+}
+
+// checkSubscription subscribes to the hub of a feed that has just been
+// fetched, if it isn't already subscribed. The hub and topic URLs are taken
+// from the Link header of the response, or failing that from the feed.
+func (s *Service) checkSubscription(feed *config.Feed, parsedFeed *gofeed.Feed, header http.Header, fetchURL string) {
+	if s.websub == nil || !*feed.WebSub {
+		return
+	}
+
+	s.websub.uncheckedMu.Lock()
+	delete(s.websub.unchecked, feed.ID)
+	s.websub.uncheckedMu.Unlock()
+
+	links := parseLinkHeader(header.Values("Link"))
+	hub, topic := links["hub"], links["self"]
+	if hub == "" {
+		hub, topic = parsedFeed.Custom["hub"], parsedFeed.FeedLink
+	}
+	if hub == "" {
+		return
+	}
+	if topic == "" {
+		topic = fetchURL
+	}
+	hub, topic = resolveURL(fetchURL, hub), resolveURL(fetchURL, topic)
+
+	sub := s.db.GetSubscription(feed.ID)
+	if sub == nil || sub.Hub != hub || sub.Topic != topic || needsRenewal(sub, time.Now()) {
+		s.goSubscribe(feed, hub, topic)
+	}
+}
+
+// needsHubCheck reports whether a feed should be fetched in full, rather than
+// with a conditional request, so that its hub can be found. Otherwise a feed
+// that doesn't change would never be subscribed.
+func (s *Service) needsHubCheck(feed *config.Feed) bool {
+	if s.websub == nil || !*feed.WebSub {
+		return false
+	}
+
+	s.websub.uncheckedMu.Lock()
+	defer s.websub.uncheckedMu.Unlock()
+	return s.websub.unchecked[feed.ID]
+}
+
+// renewSubscriptions renews subscriptions whose leases are about to expire,
+// and retries subscriptions that the hub hasn't verified.
+func (s *Service) renewSubscriptions() {
+	if s.websub == nil {
+		return
+	}
+
+	now := time.Now()
+	for _, sub := range s.db.GetSubscriptions() {
+		if feed, ok := s.websub.feeds[sub.FeedID]; ok && needsRenewal(sub, now) {
+			s.goSubscribe(feed, sub.Hub, sub.Topic)
+		}
+	}
+}
+
+// needsRenewal reports whether a subscription should be requested again.
+func needsRenewal(sub *db.Subscription, now time.Time) bool {
+	if sub.Expires == 0 {
+		return now.Sub(time.Unix(sub.Requested, 0)) >= websubRetry
+	}
+	return time.Unix(sub.Expires, 0).Sub(now) < websubRenewBefore
+}
+
+// goSubscribe subscribes to a hub in the background.
+func (s *Service) goSubscribe(feed *config.Feed, hub, topic string) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if err := s.subscribe(feed, hub, topic); err != nil && s.ctx.Err() == nil {
+			log.Printf("Failed to subscribe to WebSub hub for feed '%s': %v", feed.ID, err)
+		}
+	}()
+}
+
+// subscribe requests a subscription from a hub. The subscription is stored
+// first, because the hub may verify it before responding. Renewals keep the
+// same secret, so that pushes in flight are still accepted.
+func (s *Service) subscribe(feed *config.Feed, hub, topic string) error {
+	s.websub.mu.Lock()
+	sub := s.db.GetSubscription(feed.ID)
+	if sub != nil && (sub.Hub != hub || sub.Topic != topic) {
+		s.goSubscriptionRequest(feed, "unsubscribe", sub.Hub, sub.Topic, "")
+		sub = nil
+	}
+	if sub == nil {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			s.websub.mu.Unlock()
+			return fmt.Errorf("failed to generate secret: %w", err)
+		}
+		sub = &db.Subscription{FeedID: feed.ID, Hub: hub, Topic: topic, Secret: hex.EncodeToString(secret)}
+	}
+	sub.Requested = time.Now().Unix()
+	s.db.UpdateSubscription(sub)
+	s.websub.mu.Unlock()
+
+	return s.sendSubscriptionRequest(feed, "subscribe", hub, topic, sub.Secret)
+}
+
+// goSubscriptionRequest sends a subscription request in the background,
+// logging any error.
+func (s *Service) goSubscriptionRequest(feed *config.Feed, mode, hub, topic, secret string) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if err := s.sendSubscriptionRequest(feed, mode, hub, topic, secret); err != nil && s.ctx.Err() == nil {
+			log.Printf("Failed to send WebSub %s request for feed '%s': %v", mode, feed.ID, err)
+		}
+	}()
+}
+
+// sendSubscriptionRequest sends a subscribe or unsubscribe request to a hub,
+// using the HTTP client of the feed.
+func (s *Service) sendSubscriptionRequest(feed *config.Feed, mode, hub, topic, secret string) error {
+	form := url.Values{}
+	form.Set("hub.callback", s.callbackURL(feed))
+	form.Set("hub.mode", mode)
+	form.Set("hub.topic", topic)
+	if mode == "subscribe" {
+		form.Set("hub.lease_seconds", strconv.Itoa(s.config.WebSub.LeaseDays*86400))
+		form.Set("hub.secret", secret)
+	}
+
+	ctx, cancel := context.WithTimeout(s.ctx, time.Duration(feed.Timeout)*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", hub, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", feed.UserAgent)
+
+	resp, err := s.httpClients[feed.ID].Do(req)
+	if err != nil {
+		return fmt.Errorf("HTTP request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("hub %s returned HTTP %d", hub, resp.StatusCode)
+	}
+
+	logger.Debug("Sent WebSub %s request for feed '%s' to %s", mode, feed.ID, hub)
+	return nil
+}
+
+// handleWebSub handles requests to the callback URL of a feed.
+func (s *Service) handleWebSub(w http.ResponseWriter, r *http.Request) {
+	feed := s.websub.feeds[strings.TrimPrefix(r.URL.Path, s.websub.path)]
+
+	switch r.Method {
+	case http.MethodGet:
+		s.verifyIntent(w, r, feed)
+	case http.MethodPost:
+		s.receivePush(w, r, feed)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// verifyIntent confirms to a hub that a subscribe or unsubscribe request was
+// made by us, and records the lease of verified subscriptions.
+func (s *Service) verifyIntent(w http.ResponseWriter, r *http.Request, feed *config.Feed) {
+	query := r.URL.Query()
+	mode, topic := query.Get("hub.mode"), query.Get("hub.topic")
+
+	s.websub.mu.Lock()
+	defer s.websub.mu.Unlock()
+
+	var sub *db.Subscription
+	if feed != nil {
+		sub = s.db.GetSubscription(feed.ID)
+	}
+	wanted := sub != nil && sub.Topic == topic
+
+	switch mode {
+	case "subscribe":
+		if !wanted {
+			http.NotFound(w, r)
+			return
+		}
+		lease, err := strconv.ParseInt(query.Get("hub.lease_seconds"), 10, 64)
+		if err != nil || lease <= 0 {
+			lease = int64(s.config.WebSub.LeaseDays) * 86400
+		}
+		sub.Expires = time.Now().Unix() + lease
+		s.db.UpdateSubscription(sub)
+		logger.Debug("WebSub subscription for feed '%s' is verified until %s",
+			feed.ID, time.Unix(sub.Expires, 0).Format(time.RFC3339))
+	case "unsubscribe":
+		if wanted {
+			http.NotFound(w, r)
+			return
+		}
+	case "denied":
+		if wanted {
+			log.Printf("WebSub subscription for feed '%s' was denied by the hub: %s", feed.ID, query.Get("hub.reason"))
+			// Retry later rather than deleting it, so that it isn't
+			// requested again after every fetch.
+			sub.Expires = 0
+			sub.Requested = time.Now().Unix()
+			s.db.UpdateSubscription(sub)
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	default:
+		http.Error(w, "invalid hub.mode", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	io.WriteString(w, query.Get("hub.challenge"))
+}
+
+// receivePush accepts content pushed by a hub. The content is processed in
+// the background once its signature has been checked.
+func (s *Service) receivePush(w http.ResponseWriter, r *http.Request, feed *config.Feed) {
+	if feed == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.websub.mu.Lock()
+	sub := s.db.GetSubscription(feed.ID)
+	s.websub.mu.Unlock()
+	if sub == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, feed.MaxBodyBytes+1))
+	if err != nil {
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+	if int64(len(body)) > feed.MaxBodyBytes {
+		http.Error(w, "body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// The hub must still get a successful response if the signature is
+	// invalid, but the content is ignored.
+	w.WriteHeader(http.StatusAccepted)
+	if !validSignature(sub.Secret, r.Header.Get("X-Hub-Signature"), body) {
+		log.Printf("Ignoring WebSub content for feed '%s' with a missing or invalid signature", feed.ID)
+		return
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.processPush(feed, body)
+	}()
+}
+
+// processPush processes content pushed by a hub in the same way as a fetched
+// feed.
+func (s *Service) processPush(feed *config.Feed, body []byte) {
+	lock := s.feedLocks[feed.ID]
+	lock.Lock()
+	defer lock.Unlock()
+
+	logger.Debug("Processing WebSub content for feed '%s'", feed.ID)
+
+	parsedFeed, err := s.parser.Parse(bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Failed to parse WebSub content for feed '%s': %v", feed.ID, err)
+		return
+	}
+
+	// Don't send notifications until the feed has been fetched once, just
+	// like when it's polled.
+	if metadata := s.db.GetFeed(feed.ID); metadata == nil || metadata.LastChecked == 0 {
+		s.logItems(feed, parsedFeed.Items)
+		return
+	}
+
+	if _, err := s.processArticles(feed, parsedFeed.Items); err != nil {
+		log.Printf("Error processing WebSub content for feed '%s': %v", feed.ID, err)
+	}
+}
+
+// validSignature checks the X-Hub-Signature header of pushed content, which
+// is "method=signature", eg "sha256=...".
+func validSignature(secret, header string, body []byte) bool {
+	method, signature, ok := strings.Cut(header, "=")
+	if !ok {
+		return false
+	}
+
+	var newHash func() hash.Hash
+	switch method {
+	case "sha1":
+		newHash = sha1.New
+	case "sha256":
+		newHash = sha256.New
+	case "sha384":
+		newHash = sha512.New384
+	case "sha512":
+		newHash = sha512.New
+	default:
+		return false
+	}
+
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(newHash, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// parseLinkHeader returns the first URL of each relation in Link headers, eg
+// `<https://hub.example.com/>; rel="hub"`.
+func parseLinkHeader(values []string) map[string]string {
+	links := make(map[string]string)
+
+	for _, value := range values {
+		for _, link := range strings.Split(value, ",") {
+			target, params, _ := strings.Cut(strings.TrimSpace(link), ";")
+			target = strings.TrimSpace(target)
+			if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			target = target[1 : len(target)-1]
+
+			for _, param := range strings.Split(params, ";") {
+				name, rels, _ := strings.Cut(strings.TrimSpace(param), "=")
+				if !strings.EqualFold(strings.TrimSpace(name), "rel") {
+					continue
+				}
+				for _, rel := range strings.Fields(strings.Trim(strings.TrimSpace(rels), `"`)) {
+					rel = strings.ToLower(rel)
+					if _, exists := links[rel]; !exists {
+						links[rel] = target
+					}
+				}
+			}
+		}
+	}
+
+	return links
+}
+
+// resolveURL resolves a possibly relative URL against a base URL.
+func resolveURL(base, ref string) string {
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return ref
+	}
+	refURL, err := url.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return baseURL.ResolveReference(refURL).String()
+}
@@ -0,0 +1,366 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jamielinux/feed-notifier/internal/config"
+	"github.com/jamielinux/feed-notifier/internal/db"
+	"github.com/mmcdole/gofeed"
+)
+
+const testWebSubConfig = `
+database: %DIR%/feed-notifier.db
+fetch:
+  interval: 60
+default_notifier: stdout
+websub:
+  callback_url: "https://feeds.example.com/websub/"
+  listen: "127.0.0.1:0"
+  lease_days: 7
+feeds:
+  - id: status
+    url: "https://status.example.com/feed.atom"
+    display_name: "Status"
+    websub: true
+  - id: releases
+    url: "https://releases.example.com/feed.atom"
+    display_name: "Releases"
+    websub: true
+`
+
+const testTopic = "https://status.example.com/feed.atom"
+
+// recordingNotifier records the titles of the articles that it's sent.
+type recordingNotifier struct {
+	mu     sync.Mutex
+	titles []string
+}
+
+func (n *recordingNotifier) Notify(feed *config.Feed, item *gofeed.Item) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.titles = append(n.titles, item.Title)
+	return nil
+}
+
+func (n *recordingNotifier) sent() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]string(nil), n.titles...)
+}
+
+// newHubStandIn starts a stand-in for a WebSub hub, which accepts every
+// subscription request and sends its form to the returned channel.
+func newHubStandIn(t *testing.T) (*httptest.Server, chan url.Values) {
+	requests := make(chan url.Values, 10)
+	hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		requests <- r.PostForm
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(hub.Close)
+	return hub, requests
+}
+
+// newWebSubService creates a service with two websub feeds and starts its
+// callback server. Notifications are recorded rather than sent.
+func newWebSubService(t *testing.T) (*Service, *recordingNotifier) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config.yml")
+	configYAML := strings.ReplaceAll(testWebSubConfig, "%DIR%", dir)
+	if err := os.WriteFile(configPath, []byte(configYAML), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	database, err := db.Open(cfg.Database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(cfg, database)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notifier := &recordingNotifier{}
+	s.notifierMap["stdout"] = notifier
+	s.startWebSub()
+	t.Cleanup(func() {
+		s.Stop()
+		database.Close()
+	})
+
+	return s, notifier
+}
+
+// callback sends a request to the callback URL of a feed.
+func callback(t *testing.T, s *Service, method, feedID string, query url.Values, header http.Header,
+	body string) (int, string) {
+	t.Helper()
+
+	target := "http://" + s.websub.listener.Addr().String() + s.websub.path + feedID + "?" + query.Encode()
+	req, err := http.NewRequest(method, target, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(resp.Body)
+
+	return resp.StatusCode, string(respBody)
+}
+
+// receive waits for a request to the hub stand-in.
+func receive(t *testing.T, requests chan url.Values) url.Values {
+	t.Helper()
+
+	select {
+	case form := <-requests:
+		return form
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a request to the hub")
+		return nil
+	}
+}
+
+func TestWebSubVerifyIntent(t *testing.T) {
+	s, _ := newWebSubService(t)
+	hub, requests := newHubStandIn(t)
+	feed := s.websub.feeds["status"]
+
+	if err := s.subscribe(feed, hub.URL, testTopic); err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	form := receive(t, requests)
+	if got := form.Get("hub.mode"); got != "subscribe" {
+		t.Errorf("hub.mode = %q, want subscribe", got)
+	}
+	if got, want := form.Get("hub.callback"), "https://feeds.example.com/websub/status"; got != want {
+		t.Errorf("hub.callback = %q, want %q", got, want)
+	}
+	if got := form.Get("hub.lease_seconds"); got != "604800" {
+		t.Errorf("hub.lease_seconds = %q, want 604800", got)
+	}
+
+	sub := s.db.GetSubscription("status")
+	if sub == nil || sub.Secret == "" || sub.Secret != form.Get("hub.secret") {
+		t.Fatalf("subscription = %+v, want one with the secret sent to the hub", sub)
+	}
+	if sub.Expires != 0 {
+		t.Errorf("subscription expires before it's verified")
+	}
+
+	tests := []struct {
+		name     string
+		feedID   string
+		mode     string
+		topic    string
+		wantCode int
+	}{
+		{"wrong topic", "status", "subscribe", "https://other.example.com/", http.StatusNotFound},
+		{"unknown feed", "unknown", "subscribe", testTopic, http.StatusNotFound},
+		{"not subscribed", "releases", "subscribe", testTopic, http.StatusNotFound},
+		{"unsubscribe while subscribed", "status", "unsubscribe", testTopic, http.StatusNotFound},
+		{"unsubscribe old topic", "status", "unsubscribe", "https://other.example.com/", http.StatusOK},
+		{"invalid mode", "status", "other", testTopic, http.StatusBadRequest},
+		{"subscribe", "status", "subscribe", testTopic, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := url.Values{
+				"hub.mode":          {tt.mode},
+				"hub.topic":         {tt.topic},
+				"hub.challenge":     {"challenge-" + tt.name},
+				"hub.lease_seconds": {"3600"},
+			}
+			code, body := callback(t, s, http.MethodGet, tt.feedID, query, nil, "")
+			if code != tt.wantCode {
+				t.Fatalf("status = %d, want %d", code, tt.wantCode)
+			}
+			if code == http.StatusOK && body != "challenge-"+tt.name {
+				t.Errorf("body = %q, want the challenge", body)
+			}
+		})
+	}
+
+	sub = s.db.GetSubscription("status")
+	if wait := time.Until(time.Unix(sub.Expires, 0)); wait < 3590*time.Second || wait > 3600*time.Second {
+		t.Errorf("subscription expires in %s, want the lease of 1h", wait)
+	}
+
+	query := url.Values{"hub.mode": {"denied"}, "hub.topic": {testTopic}, "hub.reason": {"test"}}
+	if code, _ := callback(t, s, http.MethodGet, "status", query, nil, ""); code != http.StatusOK {
+		t.Fatalf("denied: status = %d, want 200", code)
+	}
+	if sub = s.db.GetSubscription("status"); sub.Expires != 0 {
+		t.Errorf("denied subscription still expires at %d", sub.Expires)
+	}
+}
+
+func TestWebSubReceivePush(t *testing.T) {
+	s, notifier := newWebSubService(t)
+	secret := "push-secret"
+	s.db.UpdateSubscription(&db.Subscription{FeedID: "status", Hub: "https://hub.example.com/", Topic: testTopic,
+		Secret: secret, Requested: time.Now().Unix(), Expires: time.Now().Add(time.Hour).Unix()})
+	s.db.UpdateFeed(&db.Feed{FeedID: "status", LastChecked: time.Now().Unix()})
+
+	atom := func(title string) string {
+		return `<?xml version="1.0"?><feed xmlns="http://www.w3.org/2005/Atom"><title>Status</title>` +
+			`<id>status</id><updated>2026-01-01T00:00:00Z</updated><entry><title>` + title +
+			`</title><id>` + title + `</id><updated>2026-01-01T00:00:00Z</updated></entry></feed>`
+	}
+	sign := func(key, body string) http.Header {
+		mac := hmac.New(sha256.New, []byte(key))
+		mac.Write([]byte(body))
+		return http.Header{"X-Hub-Signature": {"sha256=" + hex.EncodeToString(mac.Sum(nil))}}
+	}
+
+	pushes := []struct {
+		name     string
+		feedID   string
+		body     string
+		header   http.Header
+		wantCode int
+	}{
+		{"unsigned", "status", atom("unsigned"), nil, http.StatusAccepted},
+		{"wrong secret", "status", atom("wrong secret"), sign("other", atom("wrong secret")), http.StatusAccepted},
+		{"not subscribed", "releases", atom("not subscribed"), sign(secret, atom("not subscribed")), http.StatusNotFound},
+		{"signed", "status", atom("signed"), sign(secret, atom("signed")), http.StatusAccepted},
+	}
+
+	for _, push := range pushes {
+		code, _ := callback(t, s, http.MethodPost, push.feedID, nil, push.header, push.body)
+		if code != push.wantCode {
+			t.Errorf("%s push: status = %d, want %d", push.name, code, push.wantCode)
+		}
+	}
+
+	// Only the signed push is processed, in the background.
+	deadline := time.Now().Add(5 * time.Second)
+	for len(notifier.sent()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := notifier.sent(); len(got) != 1 || got[0] != "signed" {
+		t.Errorf("sent %q, want only the signed article", got)
+	}
+}
+
+func TestWebSubRenewal(t *testing.T) {
+	s, _ := newWebSubService(t)
+	hub, requests := newHubStandIn(t)
+	now := time.Now()
+
+	// The lease of status is about to expire, but releases has days left.
+	s.db.UpdateSubscription(&db.Subscription{FeedID: "status", Hub: hub.URL, Topic: testTopic,
+		Secret: "status-secret", Requested: now.Add(-7 * 24 * time.Hour).Unix(), Expires: now.Add(time.Hour).Unix()})
+	s.db.UpdateSubscription(&db.Subscription{FeedID: "releases", Hub: hub.URL, Topic: testTopic,
+		Secret: "releases-secret", Requested: now.Unix(), Expires: now.Add(3 * 24 * time.Hour).Unix()})
+
+	s.renewSubscriptions()
+
+	form := receive(t, requests)
+	if got, want := form.Get("hub.callback"), "https://feeds.example.com/websub/status"; got != want {
+		t.Errorf("renewed %q, want %q", got, want)
+	}
+	if got := form.Get("hub.secret"); got != "status-secret" {
+		t.Errorf("renewal secret = %q, want the existing secret", got)
+	}
+
+	select {
+	case form := <-requests:
+		t.Errorf("unexpected renewal of %s", form.Get("hub.callback"))
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestNeedsRenewal(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		requested time.Time
+		expires   time.Time
+		want      bool
+	}{
+		{"unverified, just requested", now.Add(-time.Hour), time.Time{}, false},
+		{"unverified, retry due", now.Add(-websubRetry), time.Time{}, true},
+		{"lease ends soon", now.Add(-7 * 24 * time.Hour), now.Add(23 * time.Hour), true},
+		{"lease has days left", now.Add(-24 * time.Hour), now.Add(6 * 24 * time.Hour), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub := &db.Subscription{Requested: tt.requested.Unix()}
+			if !tt.expires.IsZero() {
+				sub.Expires = tt.expires.Unix()
+			}
+			if got := needsRenewal(sub, now); got != tt.want {
+				t.Errorf("needsRenewal() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidSignature(t *testing.T) {
+	body := []byte("content")
+	sign := func(method string, newHash func() hash.Hash, key string) string {
+		mac := hmac.New(newHash, []byte(key))
+		mac.Write(body)
+		return method + "=" + hex.EncodeToString(mac.Sum(nil))
+	}
+	valid := sign("sha256", sha256.New, "secret")
+
+	tests := []struct {
+		name   string
+		header string
+		want   bool
+	}{
+		{"sha1", sign("sha1", sha1.New, "secret"), true},
+		{"sha256", valid, true},
+		{"sha384", sign("sha384", sha512.New384, "secret"), true},
+		{"sha512", sign("sha512", sha512.New, "secret"), true},
+		{"wrong secret", sign("sha256", sha256.New, "other"), false},
+		{"wrong method", "sha512=" + strings.TrimPrefix(valid, "sha256="), false},
+		{"unsupported method", "md5=" + strings.TrimPrefix(valid, "sha256="), false},
+		{"missing method", strings.TrimPrefix(valid, "sha256="), false},
+		{"not hex", "sha256=zz", false},
+		{"empty", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validSignature("secret", tt.header, body); got != tt.want {
+				t.Errorf("validSignature(%q) = %t, want %t", tt.header, got, tt.want)
+			}
+		})
+	}
+}